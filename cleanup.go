@@ -7,18 +7,13 @@ import (
 	"time"
 
 	"github.com/containerd/containerd"
-	"github.com/containerd/containerd/defaults"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
 
 func cleanup(ctx context.Context, id string) error {
-	client, err := containerd.New(
-		defaults.DefaultAddress,
-		containerd.WithDefaultRuntime("io.containerd.process.v1"),
-		containerd.WithTimeout(1*time.Second),
-	)
+	client, err := newClient()
 	if err != nil {
 		return err
 	}
@@ -50,13 +45,39 @@ func cleanup(ctx context.Context, id string) error {
 		return err
 	}
 	if err := task.Kill(ctx, unix.SIGKILL); err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintln(os.Stderr, err)
 	}
 	<-wait
 	_, err = task.Delete(ctx)
 	return err
 }
 
+// postStop cleans up the container's task and runs the post-stop hooks with
+// the exit status recorded by the proxy
+func postStop(ctx context.Context, config *Config) error {
+	if err := cleanup(ctx, config.ID); err != nil {
+		return err
+	}
+	vars := make(map[string]string)
+	if config.Hooks.get(PostStopHook) != nil {
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		if container, err := client.LoadContainer(ctx, config.ID); err == nil {
+			info, err := container.Info(ctx)
+			if err != nil {
+				return err
+			}
+			if status, ok := info.Labels[exitStatusLabel]; ok {
+				vars["EXIT_CODE"] = status
+			}
+		}
+	}
+	return runHooks(ctx, config, PostStopHook, vars)
+}
+
 func checkRunning(ctx context.Context, container containerd.Container) error {
 	if _, err := container.Task(ctx, nil); err != nil {
 		if errdefs.IsNotFound(err) {
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+)
+
+// binaryName is the name the proxy is installed as, when invoked under any
+// other name the name is used as the container id
+const binaryName = "containerd-proxy"
+
+type command struct {
+	usage string
+	// noConfig commands receive a config with only the id set
+	noConfig bool
+	// noID commands do not take a container id and receive an empty config
+	noID bool
+	// optionalID commands may be invoked without a container id
+	optionalID bool
+	// checked commands fail on an invalid config, others only need it to
+	// decode so that a service can be stopped after its config changed
+	checked bool
+	action  func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error
+}
+
+var commands = map[string]command{
+	"run": {
+		usage:   "run the container in the foreground, args after -- are passed to the container",
+		checked: true,
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			config.ExtraArgs = args
+			return proxy(ctx, config, signals)
+		},
+	},
+	"stop": {
+		usage: "kill and remove the container's task",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return cleanup(ctx, config.ID)
+		},
+	},
+	"post-stop": {
+		usage: "alias for stop used as the unit's ExecStopPost",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return postStop(ctx, config)
+		},
+	},
+	"status": {
+		usage: "show the state of the container",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return status(ctx, config, args)
+		},
+	},
+	"list": {
+		usage:  "list every proxied service on the host",
+		noID:   true,
+		action: list,
+	},
+	"spec": {
+		usage:  "print the runtime spec the container would be run with",
+		action: specCommand,
+	},
+	"events": {
+		usage:  "print containerd's events for the container",
+		action: eventsCommand,
+	},
+	"logs": {
+		usage: "print the container's persisted logs",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return logs(ctx, config, args)
+		},
+	},
+	"config": {
+		usage:  "show <id> prints the merged config and the file each value came from",
+		noID:   true,
+		action: configCommand,
+	},
+	"exec": {
+		usage:  "run an additional process in the container",
+		action: execCommand,
+	},
+	"fetch": {
+		usage: "stage the configured image without starting the container",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return fetch(ctx, config)
+		},
+	},
+	"upgrade": {
+		usage: "upgrade the stopped container to the configured image",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return upgrade(ctx, config)
+		},
+	},
+	"rollback": {
+		usage: "roll the stopped container back to its previous revision",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return rollback(ctx, config)
+		},
+	},
+	"plan": {
+		usage: "show the changes run would make to the container",
+		action: func(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+			return plan(ctx, config)
+		},
+	},
+	"install": {
+		usage:    "link the id to the proxy and write its systemd unit",
+		noConfig: true,
+		action:   installCommand,
+	},
+	"uninstall": {
+		usage:    "remove the id's symlink and systemd unit",
+		noConfig: true,
+		action:   uninstallCommand,
+	},
+	"validate": {
+		usage:      "check the configuration of one or, without an id, every container",
+		noConfig:   true,
+		optionalID: true,
+		action:     validate,
+	},
+}
+
+// parseArgs returns the command, container id and remaining args for the
+// invocation either as `containerd-proxy <command> <id>` or through a symlink
+// named after the container. Through a symlink only post-stop is recognized,
+// as the only arg, and a leading -- passes every following arg to the
+// container. Every other command requires the multi-call form
+func parseArgs(args []string) (string, string, []string, error) {
+	name := filepath.Base(args[0])
+	if name != binaryName {
+		args = args[1:]
+		switch {
+		case len(args) == 0:
+			return "run", name, nil, nil
+		case args[0] == "--":
+			return "run", name, args[1:], nil
+		case len(args) == 1 && args[0] == "post-stop":
+			return args[0], name, args[1:], nil
+		}
+		return "run", name, args, nil
+	}
+	if len(args) < 2 {
+		return "", "", nil, errors.New("no command specified")
+	}
+	c := args[1]
+	cmd, ok := commands[c]
+	if !ok {
+		return "", "", nil, fmt.Errorf("unknown command %q", c)
+	}
+	if cmd.noID {
+		return c, "", args[2:], nil
+	}
+	if len(args) < 3 {
+		if cmd.optionalID {
+			return c, "", nil, nil
+		}
+		return "", "", nil, fmt.Errorf("%s requires a container id", c)
+	}
+	rest := args[3:]
+	if c == "run" && len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	return c, args[2], rest, nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [--config-dir dir] [--wait duration] <command> [id] [args...]\n\ncommands:\n", binaryName)
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+// parseGlobalFlags applies the flags given before the command in multi-call
+// mode and returns the remaining args
+func parseGlobalFlags(args []string) ([]string, error) {
+	if filepath.Base(args[0]) != binaryName {
+		return args, nil
+	}
+	fs := flag.NewFlagSet(binaryName, flag.ContinueOnError)
+	fs.StringVar(&configDir, "config-dir", configDir, "directory containing the container configs")
+	fs.DurationVar(&startupWait, "wait", startupWait, "how long to wait for containerd to be serving")
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	return append([]string{args[0]}, fs.Args()...), nil
+}
+
+func run(args []string, signals chan os.Signal) error {
+	if dir := os.Getenv(configDirEnv); dir != "" {
+		configDir = dir
+	}
+	if wait := os.Getenv(startupWaitEnv); wait != "" {
+		d, err := time.ParseDuration(wait)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", startupWaitEnv, err)
+		}
+		startupWait = d
+	}
+	args, err := parseGlobalFlags(args)
+	if err != nil {
+		return err
+	}
+	name, id, rest, err := parseArgs(args)
+	if err != nil {
+		usage()
+		return err
+	}
+	c := commands[name]
+	if c.noID {
+		return c.action(context.Background(), &Config{}, rest, signals)
+	}
+	if c.noConfig {
+		return c.action(context.Background(), &Config{ID: id}, rest, signals)
+	}
+	load := loadConfigFrom
+	if c.checked {
+		load = loadValidConfigFrom
+	}
+	config, err := load(configDir, id)
+	if err != nil {
+		return err
+	}
+	ctx := namespaces.WithNamespace(context.Background(), config.Namespace)
+	return c.action(ctx, config, rest, signals)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var invocations = []struct {
+	Args    []string
+	Command string
+	ID      string
+	Rest    []string
+	Error   bool
+}{
+	{
+		Args:    []string{"/usr/bin/redis"},
+		Command: "run",
+		ID:      "redis",
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "--port", "6380"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"--port", "6380"},
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "post-stop"},
+		Command: "post-stop",
+		ID:      "redis",
+		Rest:    []string{},
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "post-stop", "now"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"post-stop", "now"},
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "--", "post-stop"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"post-stop"},
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "status"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"status"},
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "exec", "foo"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"exec", "foo"},
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "fetch"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"fetch"},
+	},
+	{
+		Args:    []string{"containerd-proxy", "fetch", "redis"},
+		Command: "fetch",
+		ID:      "redis",
+		Rest:    []string{},
+	},
+	{
+		Args:    []string{"/usr/bin/redis", "logs"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"logs"},
+	},
+	{
+		Args:    []string{"containerd-proxy", "exec", "redis", "-t", "sh"},
+		Command: "exec",
+		ID:      "redis",
+		Rest:    []string{"-t", "sh"},
+	},
+	{
+		Args:    []string{"/usr/local/bin/containerd-proxy", "status", "redis"},
+		Command: "status",
+		ID:      "redis",
+		Rest:    []string{},
+	},
+	{
+		Args:    []string{"containerd-proxy", "run", "redis", "--", "post-stop"},
+		Command: "run",
+		ID:      "redis",
+		Rest:    []string{"post-stop"},
+	},
+	{
+		Args:    []string{"containerd-proxy", "list", "-json"},
+		Command: "list",
+		Rest:    []string{"-json"},
+	},
+	{
+		Args:    []string{"containerd-proxy", "validate"},
+		Command: "validate",
+	},
+	{
+		Args:  []string{"containerd-proxy", "status"},
+		Error: true,
+	},
+	{
+		Args:  []string{"containerd-proxy", "redis"},
+		Error: true,
+	},
+	{
+		Args:  []string{"containerd-proxy"},
+		Error: true,
+	},
+}
+
+func TestParseArgs(t *testing.T) {
+	for i, inv := range invocations {
+		command, id, rest, err := parseArgs(inv.Args)
+		if inv.Error {
+			if err == nil {
+				t.Errorf("%d: expected error for %v", i, inv.Args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if command != inv.Command || id != inv.ID || !reflect.DeepEqual(rest, inv.Rest) {
+			t.Errorf("%d: expected %s %s %v but got %s %s %v", i, inv.Command, inv.ID, inv.Rest, command, id, rest)
+		}
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/errdefs"
@@ -19,32 +22,132 @@ const (
 	AnyScope   = "*"
 )
 
-func loadConfig(id string) (*Config, error) {
-	f, err := os.Open(filepath.Join("/etc/containerd-proxy", fmt.Sprintf("%s.json", id)))
+const (
+	defaultConfigDir = "/etc/containerd-proxy"
+	configDirEnv     = "CONTAINERD_PROXY_CONFIG_DIR"
+)
+
+// configDir is where configs are loaded from, it can be changed with the
+// CONTAINERD_PROXY_CONFIG_DIR environment variable or the --config-dir flag
+var configDir = defaultConfigDir
+
+// loadConfigFrom decodes the config for id without checking its values so
+// that a service can still be stopped after its config became invalid
+func loadConfigFrom(dir, id string) (*Config, error) {
+	return readConfig(dir, id, decodeConfig)
+}
+
+// loadValidConfigFrom loads the config for id and fails on any invalid value
+func loadValidConfigFrom(dir, id string) (*Config, error) {
+	return readConfig(dir, id, checkConfig)
+}
+
+func readConfig(dir, id string, read func(string, string) (*Config, validationErrors, error)) (*Config, error) {
+	c, errs, err := read(dir, id)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	var c Config
-	if err := json.NewDecoder(f).Decode(&c); err != nil {
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config %s:\n%v", configPath(dir, id), errs)
+	}
+	return c, nil
+}
+
+// configPaths returns the existing configs for id in dir in any format
+func configPaths(dir, id string) []string {
+	var paths []string
+	for _, ext := range configExtensions {
+		p := filepath.Join(dir, id+ext)
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// configPath returns the config for id in dir, the json path is returned when
+// no config exists
+func configPath(dir, id string) string {
+	if paths := configPaths(dir, id); len(paths) > 0 {
+		return paths[0]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.json", id))
+}
+
+// configIDs returns the ids of every container configured in dir
+func configIDs(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
 		return nil, err
 	}
-	c.ID = id
-	return &c, nil
+	var (
+		ids  []string
+		seen = make(map[string]bool)
+	)
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || !isConfigExtension(ext) {
+			continue
+		}
+		id := strings.TrimSuffix(f.Name(), ext)
+		if id == defaultsName || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
 type Config struct {
 	ID        string   `json:"-"`
+	ExtraArgs []string `json:"-"`
 	Namespace string   `json:"namespace"`
 	Image     string   `json:"image"`
 	ImagePath string   `json:"imagePath"`
 	Args      []string `json:"args"`
 	Scope     string   `json:"scope"`
+	Terminal  bool     `json:"terminal"`
+	Logging   *Logging `json:"logging"`
+	// ReloadSignal reloads the config instead of being sent to the container
+	ReloadSignal string     `json:"reloadSignal"`
+	Signals      *Signals   `json:"signals"`
+	Reconnect    *Reconnect `json:"reconnect"`
+	// OOMExitCode is the proxy's exit status when the task is oom killed
+	OOMExitCode int    `json:"oomExitCode"`
+	Hooks       *Hooks `json:"hooks"`
+	// OCIHooks are run by the runtime rather than the proxy
+	OCIHooks *OCIHooks `json:"ociHooks"`
+	// SpecPatch is applied to the generated spec after every other option
+	SpecPatch json.RawMessage `json:"specPatch"`
+	// User is resolved against the image's /etc/passwd and /etc/group
+	User   string   `json:"user"`
+	Groups []string `json:"groups"`
+	Umask  string   `json:"umask"`
+	Userns *Userns  `json:"userns"`
+}
+
+// Duration is a time.Duration that is encoded as a string such as "10s"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
 }
 
 // ShouldUpgrade matches the scope and image for a container to decide if an upgrade is required
 func (c *Config) ShouldUpgrade(containerImage, containerScope string) bool {
-	if c.Image != containerImage {
+	if normalizeImage(c.Image) != normalizeImage(containerImage) {
 		if containerScope == c.Scope {
 			return true
 		}
@@ -61,7 +164,8 @@ func (c *Config) ShouldUpgrade(containerImage, containerScope string) bool {
 
 // GetImage returns the image for the config
 func (c *Config) GetImage(ctx context.Context, client *containerd.Client) (containerd.Image, error) {
-	image, err := client.GetImage(ctx, c.Image)
+	name := normalizeImage(c.Image)
+	image, err := client.GetImage(ctx, name)
 	if err != nil {
 		if !errdefs.IsNotFound(err) {
 			return nil, err
@@ -70,7 +174,7 @@ func (c *Config) GetImage(ctx context.Context, client *containerd.Client) (conta
 		switch {
 		case c.ImagePath != "":
 			importer := &oci.V1Importer{
-				ImageName: c.Image,
+				ImageName: name,
 			}
 			f, err := os.Open(c.ImagePath)
 			if err != nil {
@@ -89,7 +193,7 @@ func (c *Config) GetImage(ctx context.Context, client *containerd.Client) (conta
 				return nil, err
 			}
 		default:
-			if image, err = client.Pull(ctx, c.Image, containerd.WithPullUnpack); err != nil {
+			if image, err = client.Pull(ctx, name, containerd.WithPullUnpack); err != nil {
 				return nil, err
 			}
 		}
@@ -97,9 +201,11 @@ func (c *Config) GetImage(ctx context.Context, client *containerd.Client) (conta
 	return image, nil
 }
 
+// GetArgs returns the container's process args, the container id is used as
+// the binary name followed by the configured and invocation args
 func (c *Config) GetArgs() []string {
 	args := append([]string{
-		filepath.Base(os.Args[0]),
+		c.ID,
 	}, c.Args...)
-	return append(args, os.Args[1:]...)
+	return append(args, c.ExtraArgs...)
 }
@@ -62,6 +62,15 @@ var upgrades = []struct {
 		ContainerImage: "ce02",
 		Upgrade:        true,
 	},
+	{
+		Config: Config{
+			Image: "redis:4",
+			Scope: "ce",
+		},
+		ContainerScope: "ce",
+		ContainerImage: "docker.io/library/redis:4",
+		Upgrade:        false,
+	},
 }
 
 func TestUpgrades(t *testing.T) {
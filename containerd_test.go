@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd"
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	namespacesapi "github.com/containerd/containerd/api/services/namespaces/v1"
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	versionapi "github.com/containerd/containerd/api/services/version/v1"
+	apitypes "github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/typeurl"
+	"github.com/gogo/protobuf/types"
+	"google.golang.org/grpc"
+)
+
+// fakeContainerd serves the container and task calls the proxy makes for a
+// single running task and can be stopped and started again like containerd
+type fakeContainerd struct {
+	socket  string
+	dir     string
+	pid     uint32
+	exit    chan uint32
+	events  chan *eventsapi.Envelope
+	metrics *cgroups.Metrics
+
+	mu      sync.Mutex
+	server  *grpc.Server
+	signals []uint32
+	// containers are served by namespace when set, only the ids in running
+	// have a task. Without containers every id is found with a running task
+	containers map[string]map[string]*containersapi.Container
+	running    map[string]bool
+}
+
+// add serves a container in the namespace with the labels
+func (f *fakeContainerd) add(namespace, id, image string, labels map[string]string, running bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.containers == nil {
+		f.containers = make(map[string]map[string]*containersapi.Container)
+		f.running = make(map[string]bool)
+	}
+	if f.containers[namespace] == nil {
+		f.containers[namespace] = make(map[string]*containersapi.Container)
+	}
+	f.containers[namespace][id] = &containersapi.Container{
+		ID:     id,
+		Image:  image,
+		Labels: labels,
+	}
+	f.running[id] = running
+}
+
+// container returns a copy of the container, false when it is not served
+func (f *fakeContainerd) container(ctx context.Context, id string) (containersapi.Container, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.containers == nil {
+		return containersapi.Container{ID: id}, true
+	}
+	ns, _ := namespaces.Namespace(ctx)
+	c, ok := f.containers[ns][id]
+	if !ok {
+		return containersapi.Container{}, false
+	}
+	copied := *c
+	copied.Labels = make(map[string]string)
+	for k, v := range c.Labels {
+		copied.Labels[k] = v
+	}
+	return copied, true
+}
+
+func (f *fakeContainerd) hasTask(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.containers == nil || f.running[id]
+}
+
+func newFakeContainerd(t *testing.T) *fakeContainerd {
+	dir, err := ioutil.TempDir("", "containerd-proxy-fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeContainerd{
+		socket: filepath.Join(dir, "containerd.sock"),
+		dir:    dir,
+		pid:    uint32(os.Getpid()),
+		exit:   make(chan uint32, 1),
+		events: make(chan *eventsapi.Envelope, 16),
+	}
+}
+
+func (f *fakeContainerd) start(t *testing.T) {
+	l, err := net.Listen("unix", f.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	containersapi.RegisterContainersServer(s, fakeContainers{fakeContainerd: f})
+	namespacesapi.RegisterNamespacesServer(s, fakeNamespaces{fakeContainerd: f})
+	tasksapi.RegisterTasksServer(s, fakeTasks{fakeContainerd: f})
+	versionapi.RegisterVersionServer(s, fakeVersion{})
+	eventsapi.RegisterEventsServer(s, fakeEvents{fakeContainerd: f})
+	f.mu.Lock()
+	f.server = s
+	f.mu.Unlock()
+	go s.Serve(l)
+}
+
+// client connects to the fake once it is started
+func (f *fakeContainerd) client(t *testing.T) *containerd.Client {
+	client, err := containerd.New(f.socket, containerd.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// stop closes every connection and removes the socket
+func (f *fakeContainerd) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.server != nil {
+		f.server.Stop()
+		f.server = nil
+	}
+	os.Remove(f.socket)
+}
+
+func (f *fakeContainerd) Close() {
+	f.stop()
+	os.RemoveAll(f.dir)
+}
+
+func (f *fakeContainerd) received() []uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]uint32(nil), f.signals...)
+}
+
+type fakeContainers struct {
+	*fakeContainerd
+	containersapi.ContainersServer
+}
+
+func (f fakeContainers) Get(ctx context.Context, r *containersapi.GetContainerRequest) (*containersapi.GetContainerResponse, error) {
+	c, ok := f.container(ctx, r.ID)
+	if !ok {
+		return nil, errdefs.ToGRPC(errdefs.ErrNotFound)
+	}
+	return &containersapi.GetContainerResponse{
+		Container: c,
+	}, nil
+}
+
+func (f fakeContainers) List(ctx context.Context, r *containersapi.ListContainersRequest) (*containersapi.ListContainersResponse, error) {
+	filter, err := filters.ParseAll(r.Filters...)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	ns, _ := namespaces.Namespace(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var resp containersapi.ListContainersResponse
+	for _, c := range f.containers[ns] {
+		labels := c.Labels
+		if filter.Match(filters.AdapterFunc(func(fieldpath []string) (string, bool) {
+			if len(fieldpath) == 2 && fieldpath[0] == "labels" {
+				v, ok := labels[fieldpath[1]]
+				return v, ok
+			}
+			return "", false
+		})) {
+			resp.Containers = append(resp.Containers, *c)
+		}
+	}
+	return &resp, nil
+}
+
+func (fakeContainers) ListStream(*containersapi.ListContainersRequest, containersapi.Containers_ListStreamServer) error {
+	return errdefs.ToGRPC(errdefs.ErrNotImplemented)
+}
+
+// Update applies label updates like containerd, an empty value removes the
+// label
+func (f fakeContainers) Update(ctx context.Context, r *containersapi.UpdateContainerRequest) (*containersapi.UpdateContainerResponse, error) {
+	ns, _ := namespaces.Namespace(ctx)
+	f.mu.Lock()
+	c, ok := f.containers[ns][r.Container.ID]
+	if ok {
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		for _, p := range r.UpdateMask.Paths {
+			key := strings.TrimPrefix(p, "labels.")
+			if v := r.Container.Labels[key]; v != "" {
+				c.Labels[key] = v
+			} else {
+				delete(c.Labels, key)
+			}
+		}
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPC(errdefs.ErrNotFound)
+	}
+	updated, _ := f.container(ctx, r.Container.ID)
+	return &containersapi.UpdateContainerResponse{
+		Container: updated,
+	}, nil
+}
+
+type fakeNamespaces struct {
+	*fakeContainerd
+	namespacesapi.NamespacesServer
+}
+
+func (f fakeNamespaces) List(ctx context.Context, r *namespacesapi.ListNamespacesRequest) (*namespacesapi.ListNamespacesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var resp namespacesapi.ListNamespacesResponse
+	for ns := range f.containers {
+		resp.Namespaces = append(resp.Namespaces, namespacesapi.Namespace{
+			Name: ns,
+		})
+	}
+	return &resp, nil
+}
+
+type fakeEvents struct {
+	*fakeContainerd
+	eventsapi.EventsServer
+}
+
+func (f fakeEvents) Subscribe(r *eventsapi.SubscribeRequest, s eventsapi.Events_SubscribeServer) error {
+	for {
+		select {
+		case <-s.Context().Done():
+			return nil
+		case e := <-f.events:
+			if err := s.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type fakeVersion struct{}
+
+func (fakeVersion) Version(context.Context, *types.Empty) (*versionapi.VersionResponse, error) {
+	return &versionapi.VersionResponse{
+		Version: "v1.1.0",
+	}, nil
+}
+
+type fakeTasks struct {
+	*fakeContainerd
+	tasksapi.TasksServer
+}
+
+func (f fakeTasks) Get(ctx context.Context, r *tasksapi.GetRequest) (*tasksapi.GetResponse, error) {
+	if !f.hasTask(r.ContainerID) {
+		return nil, errdefs.ToGRPC(errdefs.ErrNotFound)
+	}
+	return &tasksapi.GetResponse{
+		Process: &task.Process{
+			ID:     r.ContainerID,
+			Pid:    f.pid,
+			Status: task.StatusRunning,
+			Stdout: filepath.Join(f.dir, "stdout"),
+			Stderr: filepath.Join(f.dir, "stderr"),
+		},
+	}, nil
+}
+
+func (f fakeTasks) Metrics(ctx context.Context, r *tasksapi.MetricsRequest) (*tasksapi.MetricsResponse, error) {
+	data, err := typeurl.MarshalAny(f.metrics)
+	if err != nil {
+		return nil, err
+	}
+	return &tasksapi.MetricsResponse{
+		Metrics: []*apitypes.Metric{
+			{
+				ID:   strings.TrimPrefix(r.Filters[0], "id=="),
+				Data: data,
+			},
+		},
+	}, nil
+}
+
+func (f fakeTasks) Kill(ctx context.Context, r *tasksapi.KillRequest) (*types.Empty, error) {
+	f.mu.Lock()
+	f.signals = append(f.signals, r.Signal)
+	f.mu.Unlock()
+	return &types.Empty{}, nil
+}
+
+func (f fakeTasks) Wait(ctx context.Context, r *tasksapi.WaitRequest) (*tasksapi.WaitResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case code := <-f.exit:
+		return &tasksapi.WaitResponse{
+			ExitStatus: code,
+			ExitedAt:   time.Now(),
+		}, nil
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/typeurl"
+	"golang.org/x/sys/unix"
+
+	// register the event types for decoding
+	_ "github.com/containerd/containerd/api/events"
+)
+
+// serviceEvent is a containerd event about a proxied service
+type serviceEvent struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Namespace string      `json:"namespace"`
+	Topic     string      `json:"topic"`
+	Event     interface{} `json:"event"`
+}
+
+func (e *serviceEvent) String() string {
+	data, err := json.Marshal(e.Event)
+	if err != nil {
+		return e.Topic
+	}
+	return fmt.Sprintf("%s %s", e.Topic, data)
+}
+
+// eventFilters match the task and container events for the config's container,
+// the events for its snapshots and the image events for its image
+func eventFilters(config *Config) []string {
+	filters := []string{
+		fmt.Sprintf(`namespace==%q,topic~="^/tasks/",event.container_id==%q`, config.Namespace, config.ID),
+		fmt.Sprintf(`namespace==%q,topic~="^/containers/",event.id==%q`, config.Namespace, config.ID),
+		// flux names the container's snapshots boss.io.<id>.<timestamp>
+		fmt.Sprintf(`namespace==%q,topic~="^/snapshot/",event.key~=%q`, config.Namespace, "^"+strings.Replace("boss.io."+config.ID+".", ".", "[.]", -1)),
+	}
+	if config.Image != "" {
+		filters = append(filters, fmt.Sprintf(`namespace==%q,topic~="^/images/",event.name==%q`, config.Namespace, normalizeImage(config.Image)))
+	}
+	return filters
+}
+
+// subscription is an open stream of a service's events
+type subscription struct {
+	config *Config
+	events <-chan *events.Envelope
+	errs   <-chan error
+}
+
+// subscribe opens a stream of the service's events before returning so that
+// events published before they are received are not missed
+func subscribe(ctx context.Context, client *containerd.Client, config *Config) *subscription {
+	events, errs := client.Subscribe(ctx, eventFilters(config)...)
+	return &subscription{
+		config: config,
+		events: events,
+		errs:   errs,
+	}
+}
+
+// each calls fn with each of the service's events until the subscription's
+// context is done or it fails
+func (s *subscription) each(fn func(*serviceEvent)) error {
+	for {
+		select {
+		case e := <-s.events:
+			v, err := typeurl.UnmarshalAny(e.Event)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "events %s: %s: %v\n", s.config.ID, e.Topic, err)
+				continue
+			}
+			fn(&serviceEvent{
+				Timestamp: e.Timestamp,
+				Namespace: e.Namespace,
+				Topic:     e.Topic,
+				Event:     v,
+			})
+		case err := <-s.errs:
+			return err
+		}
+	}
+}
+
+// watchEvents logs the service's events and passes them to the exit monitor
+func watchEvents(ctx context.Context, sub *subscription, monitor *exitMonitor) {
+	id := sub.config.ID
+	err := sub.each(func(e *serviceEvent) {
+		fmt.Fprintf(os.Stderr, "event %s: %s\n", id, e)
+		monitor.handle(e.Event)
+	})
+	if err != nil && ctx.Err() == nil && !isUnavailable(err) {
+		fmt.Fprintf(os.Stderr, "events %s: %v\n", id, err)
+	}
+}
+
+// eventsCommand prints the service's events as they happen
+func eventsCommand(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	var (
+		fs     = flag.NewFlagSet("events", flag.ContinueOnError)
+		follow = fs.Bool("follow", false, "print events as they happen")
+		asJSON = fs.Bool("json", false, "print each event as a line of json")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*follow {
+		return errors.New("containerd does not keep past events, use --follow")
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for s := range signals {
+			if s == unix.SIGINT || s == unix.SIGTERM {
+				cancel()
+				return
+			}
+		}
+	}()
+	enc := json.NewEncoder(os.Stdout)
+	err = subscribe(ctx, client, config).each(func(e *serviceEvent) {
+		if *asJSON {
+			enc.Encode(e)
+			return
+		}
+		fmt.Printf("%s %s\n", e.Timestamp.Format(time.RFC3339Nano), e)
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
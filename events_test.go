@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/events"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/typeurl"
+)
+
+func TestEventFilters(t *testing.T) {
+	filters := eventFilters(&Config{ID: "redis", Namespace: "services", Image: "docker.io/library/redis:4"})
+	expected := []string{
+		`namespace=="services",topic~="^/tasks/",event.container_id=="redis"`,
+		`namespace=="services",topic~="^/containers/",event.id=="redis"`,
+		`namespace=="services",topic~="^/snapshot/",event.key~="^boss[.]io[.]redis[.]"`,
+		`namespace=="services",topic~="^/images/",event.name=="docker.io/library/redis:4"`,
+	}
+	if !reflect.DeepEqual(filters, expected) {
+		t.Errorf("expected %v but got %v", expected, filters)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	defer func(a string) {
+		containerdAddress = a
+	}(containerdAddress)
+	containerdAddress = f.socket
+	f.start(t)
+
+	client, err := dialContainerd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithCancel(namespaces.WithNamespace(context.Background(), "services"))
+	defer cancel()
+
+	published := map[string]interface{}{
+		"/tasks/start":  &events.TaskStart{ContainerID: "redis", Pid: 42},
+		"/tasks/paused": &events.TaskPaused{ContainerID: "redis"},
+		"/tasks/oom":    &events.TaskOOM{ContainerID: "redis"},
+	}
+	for _, topic := range []string{"/tasks/start", "/tasks/paused", "/tasks/oom"} {
+		any, err := typeurl.MarshalAny(published[topic])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.events <- &eventsapi.Envelope{
+			Timestamp: time.Now(),
+			Namespace: "services",
+			Topic:     topic,
+			Event:     any,
+		}
+	}
+	received := make(chan *serviceEvent, len(published))
+	go subscribe(ctx, client, &Config{ID: "redis", Namespace: "services"}).each(func(e *serviceEvent) {
+		received <- e
+	})
+	for range published {
+		select {
+		case e := <-received:
+			if !reflect.DeepEqual(e.Event, published[e.Topic]) {
+				t.Errorf("%s: expected %v but got %v", e.Topic, published[e.Topic], e.Event)
+			}
+			if _, err := json.Marshal(e); err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected events to be received")
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+// execCommand runs an additional process inside the container's running task
+func execCommand(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	var (
+		fs  = flag.NewFlagSet("exec", flag.ContinueOnError)
+		tty = fs.Bool("t", false, "allocate a terminal for the process")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("exec requires a command")
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	container, err := client.LoadContainer(ctx, config.ID)
+	if err != nil {
+		return err
+	}
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return err
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return err
+	}
+	pspec := *spec.Process
+	pspec.Args = fs.Args()
+	pspec.Terminal = *tty
+
+	ioOpts := []cio.Opt{cio.WithStdio}
+	if *tty {
+		ioOpts = append(ioOpts, cio.WithTerminal)
+	}
+	process, err := task.Exec(ctx, fmt.Sprintf("exec-%d", os.Getpid()), &pspec, cio.NewCreator(ioOpts...))
+	if err != nil {
+		return err
+	}
+	defer process.Delete(ctx)
+
+	wait, err := process.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	var con *hostTerminal
+	if *tty {
+		if con, err = newHostTerminal(os.Stdin); err != nil {
+			return err
+		}
+		if con != nil {
+			defer con.Restore()
+		}
+	}
+	var keep []os.Signal
+	if *tty {
+		keep = append(keep, unix.SIGWINCH)
+	}
+	filter, err := newSignalFilter(config, keep...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals = filter.run(ctx, signals)
+	if err := process.Start(ctx); err != nil {
+		return err
+	}
+	resize(ctx, con, process, config.ID)
+	return waitExec(ctx, config, process, wait, signals, con, *tty)
+}
+
+// waitExec forwards signals to the process until it exits and returns its
+// exit status
+func waitExec(ctx context.Context, config *Config, process containerd.Process, wait <-chan containerd.ExitStatus, signals <-chan os.Signal, con *hostTerminal, tty bool) error {
+	for {
+		select {
+		case s := <-signals:
+			if s == unix.SIGWINCH && tty {
+				resize(ctx, con, process, config.ID)
+				continue
+			}
+			if err := process.Kill(ctx, s.(syscall.Signal)); err != nil {
+				// the process has exited, its status is sent on wait
+				if errdefs.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+		case exit := <-wait:
+			if err := exit.Error(); err != nil {
+				return err
+			}
+			return &exitError{
+				Status: int(exit.ExitCode()),
+			}
+		}
+	}
+}
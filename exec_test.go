@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"golang.org/x/sys/unix"
+)
+
+// killProcess records the signals sent to it and fails with err
+type killProcess struct {
+	containerd.Process
+	signals []syscall.Signal
+	err     error
+}
+
+func (p *killProcess) Kill(ctx context.Context, s syscall.Signal, opts ...containerd.KillOpts) error {
+	p.signals = append(p.signals, s)
+	return p.err
+}
+
+func (p *killProcess) Resize(ctx context.Context, w, h uint32) error {
+	return nil
+}
+
+var execWaits = []struct {
+	name    string
+	tty     bool
+	killErr error
+	sent    []os.Signal
+	status  uint32
+	killed  []syscall.Signal
+}{
+	{
+		name:   "signals are forwarded",
+		sent:   []os.Signal{unix.SIGTERM},
+		status: 143,
+		killed: []syscall.Signal{unix.SIGTERM},
+	},
+	{
+		name:    "kill after exit",
+		killErr: errdefs.ErrNotFound,
+		sent:    []os.Signal{unix.SIGINT},
+		status:  3,
+		killed:  []syscall.Signal{unix.SIGINT},
+	},
+	{
+		name:   "window changes with a terminal",
+		tty:    true,
+		sent:   []os.Signal{unix.SIGWINCH, unix.SIGHUP},
+		status: 0,
+		killed: []syscall.Signal{unix.SIGHUP},
+	},
+}
+
+func TestWaitExec(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	f.start(t)
+
+	client := f.client(t)
+	defer client.Close()
+	ctx := namespaces.WithNamespace(context.Background(), "services")
+	container, err := client.LoadContainer(ctx, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range execWaits {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				p       = &killProcess{err: tc.killErr}
+				signals = make(chan os.Signal, len(tc.sent))
+				done    = make(chan error, 1)
+			)
+			wait, err := task.Wait(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tc.sent {
+				signals <- s
+			}
+			go func() {
+				done <- waitExec(ctx, &Config{ID: "redis"}, p, wait, signals, nil, tc.tty)
+			}()
+			// exit once every signal has been handled
+			for len(signals) > 0 {
+				runtime.Gosched()
+			}
+			f.exit <- tc.status
+			err = <-done
+			if eerr, ok := err.(*exitError); !ok || eerr.Status != int(tc.status) {
+				t.Errorf("expected exit status %d but got %v", tc.status, err)
+			}
+			if !reflect.DeepEqual(p.signals, tc.killed) {
+				t.Errorf("expected %v to be sent but got %v", tc.killed, p.signals)
+			}
+		})
+	}
+}
+
+func TestWaitExecKillError(t *testing.T) {
+	var (
+		p       = &killProcess{err: errdefs.ErrUnavailable}
+		signals = make(chan os.Signal, 1)
+	)
+	signals <- unix.SIGTERM
+	if err := waitExec(context.Background(), &Config{ID: "redis"}, p, nil, signals, nil, false); !errdefs.IsUnavailable(err) {
+		t.Errorf("expected the kill error to be returned but got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	eventsapi "github.com/containerd/containerd/api/events"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultOOMExitCode is the status of a process killed with SIGKILL
+	defaultOOMExitCode = 128 + int(unix.SIGKILL)
+	// exitEventTimeout is how long to wait for the exit event after the task
+	// exits so that an oom event published before it is seen
+	exitEventTimeout = time.Second
+)
+
+func (c *Config) oomExitCode() int {
+	if c.OOMExitCode == 0 {
+		return defaultOOMExitCode
+	}
+	return c.OOMExitCode
+}
+
+// exitStatus returns the proxy's exit status for the task's exit status along
+// with the cause of the exit. The shim reports signal deaths as 128+signo,
+// statuses that cannot be returned by a process are reported as 255. An oom
+// event only applies when the task was killed, it is published for any process
+// in the cgroup
+func exitStatus(config *Config, status uint32, oom bool) (int, string) {
+	switch {
+	case oom && status == uint32(defaultOOMExitCode):
+		return config.oomExitCode(), "killed by the oom killer"
+	case status == containerd.UnknownExitStatus:
+		return int(status), "unknown exit status"
+	case status > 255:
+		return 255, fmt.Sprintf("invalid exit status %d", status)
+	case status > 128 && status <= 128+64:
+		if name := unix.SignalName(syscall.Signal(status - 128)); name != "" {
+			return int(status), "killed by " + name
+		}
+	}
+	return int(status), fmt.Sprintf("exited with status %d", status)
+}
+
+// exitMonitor records whether the task was oom killed from its events
+type exitMonitor struct {
+	id     string
+	exited chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	oom bool
+}
+
+func newExitMonitor(id string) *exitMonitor {
+	return &exitMonitor{
+		id:     id,
+		exited: make(chan struct{}),
+	}
+}
+
+// handle records the task's oom and exit events
+func (m *exitMonitor) handle(event interface{}) {
+	switch e := event.(type) {
+	case *eventsapi.TaskOOM:
+		m.mu.Lock()
+		m.oom = true
+		m.mu.Unlock()
+	case *eventsapi.TaskExit:
+		if e.ID == m.id {
+			m.once.Do(func() { close(m.exited) })
+		}
+	}
+}
+
+// oomKilled returns true when the task was oom killed, waiting up to timeout
+// for the task's exit event
+func (m *exitMonitor) oomKilled(timeout time.Duration) bool {
+	select {
+	case <-m.exited:
+	case <-time.After(timeout):
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.oom
+}
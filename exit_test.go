@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/api/events"
+)
+
+var exitStatuses = []struct {
+	config   Config
+	status   uint32
+	oom      bool
+	expected int
+	cause    string
+}{
+	{status: 0, expected: 0, cause: "exited with status 0"},
+	{status: 3, expected: 3, cause: "exited with status 3"},
+	{status: 143, expected: 143, cause: "killed by SIGTERM"},
+	{status: 137, oom: true, expected: 137, cause: "killed by the oom killer"},
+	{config: Config{OOMExitCode: 90}, status: 137, oom: true, expected: 90, cause: "killed by the oom killer"},
+	{config: Config{OOMExitCode: 90}, status: 0, oom: true, expected: 0, cause: "exited with status 0"},
+	{config: Config{OOMExitCode: 90}, status: 3, oom: true, expected: 3, cause: "exited with status 3"},
+	{status: containerd.UnknownExitStatus, expected: 255, cause: "unknown exit status"},
+	{status: 512, expected: 255, cause: "invalid exit status 512"},
+}
+
+func TestExitStatus(t *testing.T) {
+	for _, tc := range exitStatuses {
+		status, cause := exitStatus(&tc.config, tc.status, tc.oom)
+		if status != tc.expected || cause != tc.cause {
+			t.Errorf("%d: expected %d %q but got %d %q", tc.status, tc.expected, tc.cause, status, cause)
+		}
+	}
+}
+
+func TestExitMonitor(t *testing.T) {
+	monitor := newExitMonitor("redis")
+	monitor.handle(&events.TaskOOM{ContainerID: "redis"})
+	monitor.handle(&events.TaskExit{ContainerID: "redis", ID: "exec-1", ExitStatus: 0})
+	monitor.handle(&events.TaskExit{ContainerID: "redis", ID: "redis", ExitStatus: 137})
+	if !monitor.oomKilled(5 * time.Second) {
+		t.Fatal("expected the task to be oom killed")
+	}
+
+	clean := newExitMonitor("redis")
+	clean.handle(&events.TaskExit{ContainerID: "redis", ID: "exec-1", ExitStatus: 0})
+	start := time.Now()
+	if clean.oomKilled(100 * time.Millisecond) {
+		t.Error("expected the task not to be oom killed without events")
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("expected to wait for the task's exit event")
+	}
+}
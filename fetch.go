@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+)
+
+const gcRootLabel = "containerd.io/gc.root"
+
+// fetch stages the configured image in containerd without creating or updating
+// the container so that a later start can swap to it
+func fetch(ctx context.Context, config *Config) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	image, err := config.GetImage(ctx, client)
+	if err != nil {
+		return err
+	}
+	unpacked, err := image.IsUnpacked(ctx, containerd.DefaultSnapshotter)
+	if err != nil {
+		return err
+	}
+	if !unpacked {
+		if err := image.Unpack(ctx, containerd.DefaultSnapshotter); err != nil {
+			return err
+		}
+	}
+	if err := pinImage(ctx, client, image); err != nil {
+		return err
+	}
+	fmt.Printf("staged %s %s for %s\n", image.Name(), image.Target().Digest, config.ID)
+	return nil
+}
+
+// pinImage labels the image as a gc root so it is kept until the container
+// takes a snapshot from it
+func pinImage(ctx context.Context, client *containerd.Client, image containerd.Image) error {
+	_, err := client.ImageService().Update(ctx, images.Image{
+		Name: image.Name(),
+		Labels: map[string]string{
+			gcRootLabel: time.Now().UTC().Format(time.RFC3339),
+		},
+	}, "labels."+gcRootLabel)
+	return err
+}
+
+// unpinImages removes the gc root label added by fetch from the named images
+func unpinImages(ctx context.Context, client *containerd.Client, names ...string) error {
+	for _, name := range names {
+		_, err := client.ImageService().Update(ctx, images.Image{
+			Name: name,
+		}, "labels."+gcRootLabel)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configExtensions are the supported config formats in order of precedence
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+func isConfigExtension(ext string) bool {
+	for _, e := range configExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeLayer decodes a config file in the format for its extension into the
+// generic form used to merge and validate configs
+func decodeLayer(ext string, data []byte) (map[string]interface{}, error) {
+	var layer map[string]interface{}
+	switch ext {
+	case ".json":
+		if err := json.Unmarshal(data, &layer); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return map[string]interface{}{}, nil
+		}
+		v, err := normalizeYAML(v)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config must be a mapping")
+		}
+		layer = m
+	case ".toml":
+		if _, err := toml.Decode(string(data), &layer); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", ext)
+	}
+	if layer == nil {
+		layer = make(map[string]interface{})
+	}
+	return layer, nil
+}
+
+// normalizeYAML converts yaml mappings to use string keys like json objects
+func normalizeYAML(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("mapping key %v is not a string", k)
+			}
+			n, err := normalizeYAML(e)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = n
+		}
+		return m, nil
+	case []interface{}:
+		for i, e := range t {
+			n, err := normalizeYAML(e)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = n
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var formatConfig = &Config{
+	ID:        "redis",
+	Namespace: "services",
+	Image:     "docker.io/library/redis:latest",
+	Args:      []string{"--port", "6380"},
+	Scope:     "ce",
+	Terminal:  true,
+	Logging: &Logging{
+		Driver:   SyslogDriver,
+		MaxSize:  1024,
+		MaxAge:   Duration{time.Hour},
+		Address:  "tcp+tls://logs.example.com:6514",
+		Facility: "local0",
+		TLS: &SyslogTLS{
+			ServerName: "logs.example.com",
+		},
+	},
+}
+
+func TestConfigFormats(t *testing.T) {
+	data, err := json.Marshal(formatConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	removeNulls(generic)
+	encoders := map[string]func(interface{}) ([]byte, error){
+		".json": json.Marshal,
+		".yaml": yaml.Marshal,
+		".yml":  yaml.Marshal,
+		".toml": func(v interface{}) ([]byte, error) {
+			var b bytes.Buffer
+			err := toml.NewEncoder(&b).Encode(v)
+			return b.Bytes(), err
+		},
+	}
+	for ext, encode := range encoders {
+		dir, err := ioutil.TempDir("", "containerd-proxy-formats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		data, err := encode(generic)
+		if err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "redis"+ext), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := loadConfigFrom(dir, "redis")
+		if err != nil {
+			t.Errorf("%s: %v", ext, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, formatConfig) {
+			t.Errorf("%s: expected %+v but got %+v", ext, formatConfig, c)
+		}
+	}
+}
+
+func TestConfigFormatsValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-formats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"redis.yaml": "namespace: services\nimage: docker.io/library/redis:latest\nimagepath: /tmp/redis.tar\n",
+		"nginx.toml": "namespace = \"services\"\nimage = \"docker.io/library/nginx:latest\"\n\n[logging]\ndriver = \"file\"\nmaxsize = 10\n",
+	}
+	expected := map[string]string{
+		"redis": "$.imagepath",
+		"nginx": "$.logging.maxsize",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids, err := configIDs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"nginx", "redis"}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+	for id, path := range expected {
+		_, errs, err := checkConfig(dir, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != 1 || errs[0].Path != path {
+			t.Errorf("%s: expected error for %s but got %v", id, path, errs)
+		}
+	}
+}
+
+func removeNulls(m map[string]interface{}) {
+	for k, v := range m {
+		switch t := v.(type) {
+		case nil:
+			delete(m, k)
+		case map[string]interface{}:
+			removeNulls(t)
+		}
+	}
+}
+
+func TestConfigDirFlag(t *testing.T) {
+	defer func(dir string) {
+		configDir = dir
+	}(configDir)
+
+	defer func(d time.Duration) {
+		startupWait = d
+	}(startupWait)
+
+	args, err := parseGlobalFlags([]string{"containerd-proxy", "--config-dir", "/tmp/proxy", "--wait", "2m", "status", "redis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configDir != "/tmp/proxy" {
+		t.Errorf("expected config dir /tmp/proxy but got %s", configDir)
+	}
+	if startupWait != 2*time.Minute {
+		t.Errorf("expected startup wait 2m but got %s", startupWait)
+	}
+	if !reflect.DeepEqual(args, []string{"containerd-proxy", "status", "redis"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+}
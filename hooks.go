@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lifecycle events that hooks run for
+const (
+	PreCreateHook   = "pre-create"
+	PreStartHook    = "pre-start"
+	PostStartHook   = "post-start"
+	PreUpgradeHook  = "pre-upgrade"
+	PostUpgradeHook = "post-upgrade"
+	PostStopHook    = "post-stop"
+)
+
+// failure policies for hooks
+const (
+	HookFail   = "fail"
+	HookIgnore = "ignore"
+)
+
+const (
+	defaultHookTimeout = time.Minute
+	hookEnvPrefix      = "CONTAINERD_PROXY_"
+)
+
+// Hooks are commands run on the host around the container's lifecycle
+type Hooks struct {
+	PreCreate   []Hook `json:"preCreate"`
+	PreStart    []Hook `json:"preStart"`
+	PostStart   []Hook `json:"postStart"`
+	PreUpgrade  []Hook `json:"preUpgrade"`
+	PostUpgrade []Hook `json:"postUpgrade"`
+	PostStop    []Hook `json:"postStop"`
+}
+
+// Hook is a host command, the event is described to it with CONTAINERD_PROXY_
+// environment variables such as CONTAINERD_PROXY_EVENT and CONTAINERD_PROXY_ID
+type Hook struct {
+	// Args is the command and its arguments
+	Args    []string `json:"args"`
+	Env     []string `json:"env"`
+	Timeout Duration `json:"timeout"`
+	// OnFailure is fail to abort the event, the default, or ignore
+	OnFailure string `json:"onFailure"`
+}
+
+func (h *Hooks) get(event string) []Hook {
+	if h == nil {
+		return nil
+	}
+	switch event {
+	case PreCreateHook:
+		return h.PreCreate
+	case PreStartHook:
+		return h.PreStart
+	case PostStartHook:
+		return h.PostStart
+	case PreUpgradeHook:
+		return h.PreUpgrade
+	case PostUpgradeHook:
+		return h.PostUpgrade
+	case PostStopHook:
+		return h.PostStop
+	}
+	return nil
+}
+
+func (h *Hooks) validate(path string) validationErrors {
+	var errs validationErrors
+	for name, hooks := range map[string][]Hook{
+		"preCreate":   h.PreCreate,
+		"preStart":    h.PreStart,
+		"postStart":   h.PostStart,
+		"preUpgrade":  h.PreUpgrade,
+		"postUpgrade": h.PostUpgrade,
+		"postStop":    h.PostStop,
+	} {
+		for i, hook := range hooks {
+			p := fmt.Sprintf("%s.%s[%d]", path, name, i)
+			if len(hook.Args) == 0 || hook.Args[0] == "" {
+				errs.add(p+".args", "a command is required")
+			}
+			for j, e := range hook.Env {
+				if !strings.Contains(e, "=") {
+					errs.add(fmt.Sprintf("%s.env[%d]", p, j), "must be in the form KEY=value")
+				}
+			}
+			if hook.Timeout.Duration < 0 {
+				errs.add(p+".timeout", "must not be negative")
+			}
+			switch hook.OnFailure {
+			case "", HookFail, HookIgnore:
+			default:
+				errs.add(p+".onFailure", "unknown policy %q, expected %s or %s", hook.OnFailure, HookFail, HookIgnore)
+			}
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+	return errs
+}
+
+// runHooks runs the config's hooks for event in order, vars are added to the
+// hook's environment prefixed with CONTAINERD_PROXY_
+func runHooks(ctx context.Context, config *Config, event string, vars map[string]string) error {
+	hooks := config.Hooks.get(event)
+	if len(hooks) == 0 {
+		return nil
+	}
+	env := append(os.Environ(),
+		hookEnvPrefix+"EVENT="+event,
+		hookEnvPrefix+"ID="+config.ID,
+		hookEnvPrefix+"NAMESPACE="+config.Namespace,
+		hookEnvPrefix+"IMAGE="+config.Image,
+	)
+	for k, v := range vars {
+		env = append(env, hookEnvPrefix+k+"="+v)
+	}
+	for _, h := range hooks {
+		if err := runHook(ctx, h, env); err != nil {
+			err = errors.Wrapf(err, "%s hook %s", event, h.Args[0])
+			if h.OnFailure == HookIgnore {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", config.ID, err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, h Hook, env []string) error {
+	timeout := h.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.Args[0], h.Args[1:]...)
+	cmd.Env = append(env, h.Env...)
+	// the proxy's stdout may be the container's output
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.Errorf("timed out after %s", timeout)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	config := &Config{
+		ID:        "redis",
+		Namespace: "services",
+		Image:     "docker.io/library/redis:5",
+		Hooks: &Hooks{
+			PreUpgrade: []Hook{
+				{
+					Args: []string{"/bin/sh", "-c", `echo "$CONTAINERD_PROXY_EVENT $CONTAINERD_PROXY_ID $CONTAINERD_PROXY_OLD_IMAGE $CONTAINERD_PROXY_NEW_IMAGE $REASON" >> ` + out},
+					Env:  []string{"REASON=migrate"},
+				},
+				{
+					Args:      []string{"/bin/false"},
+					OnFailure: HookIgnore,
+				},
+				{
+					Args: []string{"/bin/sh", "-c", "echo second >> " + out},
+				},
+			},
+		},
+	}
+	if err := runHooks(context.Background(), config, PreUpgradeHook, map[string]string{
+		"OLD_IMAGE": "docker.io/library/redis:4",
+		"NEW_IMAGE": "docker.io/library/redis:5",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "pre-upgrade redis docker.io/library/redis:4 docker.io/library/redis:5 migrate\nsecond\n"
+	if string(data) != expected {
+		t.Errorf("expected %q but got %q", expected, data)
+	}
+	if err := runHooks(context.Background(), config, PostStopHook, nil); err != nil {
+		t.Errorf("expected no error without hooks for the event: %v", err)
+	}
+}
+
+func TestRunHooksFailure(t *testing.T) {
+	config := &Config{
+		ID: "redis",
+		Hooks: &Hooks{
+			PreStart: []Hook{
+				{
+					Args: []string{"/bin/false"},
+				},
+			},
+			PostStart: []Hook{
+				{
+					Args:    []string{"/bin/sleep", "10"},
+					Timeout: Duration{100 * time.Millisecond},
+				},
+			},
+		},
+	}
+	err := runHooks(context.Background(), config, PreStartHook, nil)
+	if err == nil || !strings.Contains(err.Error(), "pre-start hook /bin/false") {
+		t.Errorf("expected the pre-start hook to fail but got %v", err)
+	}
+	start := time.Now()
+	err = runHooks(context.Background(), config, PostStartHook, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected the post-start hook to time out but got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the hook to be killed after its timeout but took %s", d)
+	}
+}
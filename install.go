@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	binDir  = "/usr/local/bin"
+	unitDir = "/etc/systemd/system"
+)
+
+const defaultUnitTemplate = `[Unit]
+Description=containerd-proxy {{.ID}}
+After=containerd.service
+Requires=containerd.service
+
+[Service]
+Type=notify
+{{range .Environment}}Environment={{.}}
+{{end}}ExecStart={{.Exec}}
+ExecStopPost={{.Exec}} post-stop
+KillMode=process
+Restart=always
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// installer creates the files for a proxied service under root
+type installer struct {
+	root     string
+	binary   string
+	template string
+}
+
+// unit is the data available to unit templates
+type unit struct {
+	*Config
+	Exec        string
+	ConfigPath  string
+	Environment []string
+}
+
+func (i *installer) path(p string) string {
+	return filepath.Join(i.root, p)
+}
+
+// install writes the config when one is provided, links the id to the proxy
+// binary and renders the service's unit file
+func (i *installer) install(id, config string) (err error) {
+	if config != "" {
+		var restore func()
+		if restore, err = i.copyConfig(id, config); err != nil {
+			return err
+		}
+		// leave the previous config in place when the install fails
+		defer func() {
+			if err != nil {
+				restore()
+			}
+		}()
+	}
+	c, err := loadValidConfigFrom(i.path(configDir), id)
+	if err != nil {
+		return err
+	}
+	text := defaultUnitTemplate
+	if i.template != "" {
+		data, err := ioutil.ReadFile(i.template)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+	t, err := template.New("unit").Parse(text)
+	if err != nil {
+		return err
+	}
+	u := unit{
+		Config:     c,
+		Exec:       filepath.Join(binDir, id),
+		ConfigPath: strings.TrimPrefix(configPath(i.path(configDir), id), strings.TrimSuffix(i.root, "/")),
+	}
+	if configDir != defaultConfigDir {
+		u.Environment = append(u.Environment, configDirEnv+"="+configDir)
+	}
+	if startupWait != defaultStartupWait {
+		u.Environment = append(u.Environment, startupWaitEnv+"="+startupWait.String())
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, u); err != nil {
+		return err
+	}
+	link := i.path(filepath.Join(binDir, id))
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(i.binary, link); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(i.path(unitDir), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(i.unitPath(id), b.Bytes(), 0644)
+}
+
+// copyConfig replaces any existing config for id with the given file, the
+// returned func puts the previous config back
+func (i *installer) copyConfig(id, config string) (func(), error) {
+	ext := filepath.Ext(config)
+	if !isConfigExtension(ext) {
+		return nil, fmt.Errorf("unsupported config format %q", ext)
+	}
+	data, err := ioutil.ReadFile(config)
+	if err != nil {
+		return nil, err
+	}
+	dir := i.path(configDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// replace any existing config in another format
+	previous := make(map[string][]byte)
+	for _, p := range configPaths(dir, id) {
+		if previous[p], err = ioutil.ReadFile(p); err != nil {
+			return nil, err
+		}
+	}
+	target := filepath.Join(dir, id+ext)
+	restore := func() {
+		os.Remove(target)
+		for p, data := range previous {
+			ioutil.WriteFile(p, data, 0644)
+		}
+	}
+	for p := range previous {
+		if err := os.Remove(p); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+	if err := ioutil.WriteFile(target, data, 0644); err != nil {
+		restore()
+		return nil, err
+	}
+	return restore, nil
+}
+
+// uninstall removes the unit file and symlink for the service and the
+// config when purge is set
+func (i *installer) uninstall(id string, purge bool) error {
+	if err := os.Remove(i.unitPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	link := i.path(filepath.Join(binDir, id))
+	if info, err := os.Lstat(link); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s is not a symlink", link)
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	if purge {
+		for _, p := range configPaths(i.path(configDir), id) {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (i *installer) unitPath(id string) string {
+	return i.path(filepath.Join(unitDir, id+".service"))
+}
+
+func installCommand(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var (
+		fs = flag.NewFlagSet("install", flag.ContinueOnError)
+		i  = &installer{}
+		c  = fs.String("config", "", "config file to install for the service")
+	)
+	fs.StringVar(&i.root, "root", "/", "root directory to install into")
+	fs.StringVar(&i.binary, "binary", binary, "path of the proxy binary to link to")
+	fs.StringVar(&i.template, "template", "", "unit file template to render")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := i.install(config.ID, *c); err != nil {
+		return err
+	}
+	fmt.Printf("installed %s, run systemctl daemon-reload to load %s\n", config.ID, i.unitPath(config.ID))
+	return nil
+}
+
+func uninstallCommand(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	var (
+		fs    = flag.NewFlagSet("uninstall", flag.ContinueOnError)
+		i     = &installer{}
+		purge = fs.Bool("purge", false, "remove the service's config")
+	)
+	fs.StringVar(&i.root, "root", "/", "root directory to uninstall from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := i.uninstall(config.ID, *purge); err != nil {
+		return err
+	}
+	fmt.Printf("uninstalled %s\n", config.ID)
+	return nil
+}
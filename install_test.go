@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstall(t *testing.T) {
+	root, err := ioutil.TempDir("", "containerd-proxy-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	config := filepath.Join(root, "redis.json")
+	if err := ioutil.WriteFile(config, []byte(`{"namespace":"services","image":"docker.io/library/redis:latest"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := &installer{
+		root:   root,
+		binary: "/usr/local/bin/containerd-proxy",
+	}
+	if err := i.install("redis", config); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(filepath.Join(root, binDir, "redis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != i.binary {
+		t.Errorf("expected symlink to %s but got %s", i.binary, target)
+	}
+	if _, err := loadConfigFrom(filepath.Join(root, configDir), "redis"); err != nil {
+		t.Errorf("expected config to be installed: %v", err)
+	}
+	data, err := ioutil.ReadFile(i.unitPath("redis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Type=notify",
+		"KillMode=process",
+		"Requires=containerd.service",
+		"ExecStart=/usr/local/bin/redis\n",
+		"ExecStopPost=/usr/local/bin/redis post-stop\n",
+	} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("expected unit to contain %q", line)
+		}
+	}
+
+	if err := i.uninstall("redis", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{
+		i.unitPath("redis"),
+		filepath.Join(root, binDir, "redis"),
+		configPath(filepath.Join(root, configDir), "redis"),
+	} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", p)
+		}
+	}
+}
+
+func TestInstallTemplate(t *testing.T) {
+	root, err := ioutil.TempDir("", "containerd-proxy-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, configDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configPath(filepath.Join(root, configDir), "redis"), []byte(`{"namespace":"services","image":"redis:4"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := filepath.Join(root, "unit.tmpl")
+	if err := ioutil.WriteFile(tmpl, []byte("{{.ID}} {{.Image}} {{.ConfigPath}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := &installer{
+		root:     root,
+		binary:   "/usr/local/bin/containerd-proxy",
+		template: tmpl,
+	}
+	if err := i.install("redis", ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(i.unitPath("redis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "redis redis:4 /etc/containerd-proxy/redis.json"; string(data) != expected {
+		t.Errorf("expected unit %q but got %q", expected, data)
+	}
+}
+
+func TestInstallInvalidConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "containerd-proxy-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, configDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := []byte(`namespace = "services"` + "\nimage = \"redis:4\"\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "redis.toml"), previous, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(root, "redis.json")
+	if err := ioutil.WriteFile(config, []byte(`{"namespace":"services"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := &installer{
+		root:   root,
+		binary: "/usr/local/bin/containerd-proxy",
+	}
+	if err := i.install("redis", config); err == nil {
+		t.Fatal("expected a config without an image to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "redis.json")); !os.IsNotExist(err) {
+		t.Error("expected the invalid config to be removed")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "redis.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(previous) {
+		t.Errorf("expected the previous config to be restored but got %q", data)
+	}
+	if _, err := os.Stat(i.unitPath("redis")); !os.IsNotExist(err) {
+		t.Error("expected no unit to be written")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const machineIDPath = "/etc/machine-id"
+
+// builtinVars are available to every config and take precedence over the
+// host's environment
+var builtinVars = map[string]func(c *Config) (string, error){
+	"ID": func(c *Config) (string, error) {
+		return c.ID, nil
+	},
+	"NAMESPACE": func(c *Config) (string, error) {
+		return c.Namespace, nil
+	},
+	"HOSTNAME": func(c *Config) (string, error) {
+		return os.Hostname()
+	},
+	"MACHINE_ID": func(c *Config) (string, error) {
+		data, err := ioutil.ReadFile(machineIDPath)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	},
+}
+
+// interpolate expands ${VAR} and ${VAR:-default} in the config's image, image
+// path and args using the builtin variables and lookup for everything else,
+// $$ escapes a literal $
+func (c *Config) interpolate(lookup func(string) (string, bool)) validationErrors {
+	var (
+		errs validationErrors
+		vars = func(name string) (string, bool, error) {
+			if fn, ok := builtinVars[name]; ok {
+				v, err := fn(c)
+				return v, err == nil, err
+			}
+			v, ok := lookup(name)
+			return v, ok, nil
+		}
+		expand = func(path string, s *string) {
+			v, err := expandVars(*s, vars)
+			if err != nil {
+				errs.add(path, "%v", err)
+				return
+			}
+			*s = v
+		}
+	)
+	expand("$.image", &c.Image)
+	expand("$.imagePath", &c.ImagePath)
+	for i := range c.Args {
+		expand(fmt.Sprintf("$.args[%d]", i), &c.Args[i])
+	}
+	return errs
+}
+
+func expandVars(s string, vars func(string) (string, bool, error)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+			continue
+		case '{':
+		default:
+			b.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", s)
+		}
+		expr := s[i+2 : i+end]
+		name, def, hasDefault := expr, "", false
+		if j := strings.Index(expr, ":-"); j >= 0 {
+			name, def, hasDefault = expr[:j], expr[j+2:], true
+		}
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in %q", s)
+		}
+		v, ok, err := vars(name)
+		if err != nil {
+			return "", fmt.Errorf("variable %s: %v", name, err)
+		}
+		switch {
+		case ok && (v != "" || !hasDefault):
+			b.WriteString(v)
+		case hasDefault:
+			b.WriteString(def)
+		default:
+			return "", fmt.Errorf("undefined variable %s", name)
+		}
+		i += end
+	}
+	return b.String(), nil
+}
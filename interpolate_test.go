@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+var expansions = []struct {
+	Value    string
+	Expected string
+	Error    bool
+}{
+	{Value: "docker.io/library/redis:${TAG}", Expected: "docker.io/library/redis:4"},
+	{Value: "docker.io/library/redis:${MISSING:-latest}", Expected: "docker.io/library/redis:latest"},
+	{Value: "${EMPTY:-fallback}", Expected: "fallback"},
+	{Value: "${EMPTY}", Expected: ""},
+	{Value: "--name=${ID}-${NAMESPACE}", Expected: "--name=redis-services"},
+	{Value: "$${TAG} costs $5", Expected: "${TAG} costs $5"},
+	{Value: "trailing $", Expected: "trailing $"},
+	{Value: "${MISSING}", Error: true},
+	{Value: "${TAG", Error: true},
+	{Value: "${}", Error: true},
+}
+
+func TestExpandVars(t *testing.T) {
+	c := &Config{
+		ID:        "redis",
+		Namespace: "services",
+	}
+	env := map[string]string{
+		"TAG":   "4",
+		"EMPTY": "",
+		"ID":    "overridden",
+	}
+	vars := func(name string) (string, bool, error) {
+		if fn, ok := builtinVars[name]; ok {
+			v, err := fn(c)
+			return v, err == nil, err
+		}
+		v, ok := env[name]
+		return v, ok, nil
+	}
+	for i, e := range expansions {
+		v, err := expandVars(e.Value, vars)
+		if e.Error {
+			if err == nil {
+				t.Errorf("%d: expected error for %q but got %q", i, e.Value, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if v != e.Expected {
+			t.Errorf("%d: expected %q but got %q", i, e.Expected, v)
+		}
+	}
+}
+
+func TestInterpolateConfig(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{
+		ID:    "redis",
+		Image: "docker.io/library/redis:${TAG:-latest}",
+		Args:  []string{"--host", "${HOSTNAME}", "${UNDEFINED}"},
+	}
+	errs := c.interpolate(func(string) (string, bool) {
+		return "", false
+	})
+	if len(errs) != 1 || errs[0].Path != "$.args[2]" {
+		t.Errorf("expected undefined variable error for $.args[2] but got %v", errs)
+	}
+	if c.Image != "docker.io/library/redis:latest" {
+		t.Errorf("unexpected image %s", c.Image)
+	}
+	if c.Args[1] != hostname {
+		t.Errorf("expected hostname %s but got %s", hostname, c.Args[1])
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	JournaldDriver = "journald"
+
+	defaultJournalSocket = "/run/systemd/journal/socket"
+
+	// syslog priorities used for each of the container's streams
+	stdoutPriority = 6
+	stderrPriority = 3
+)
+
+// journal writes container output directly to the systemd journal using its
+// native datagram protocol
+type journal struct {
+	mu      sync.Mutex
+	conn    *net.UnixConn
+	addr    *net.UnixAddr
+	fields  map[string]string
+	streams []*lineWriter
+	// dropped counts the entries that could not be sent
+	dropped uint64
+}
+
+func newJournal(socket string, fields map[string]string) (*journal, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{
+		Net: "unixgram",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &journal{
+		conn: conn,
+		addr: &net.UnixAddr{
+			Name: socket,
+			Net:  "unixgram",
+		},
+		fields: fields,
+	}, nil
+}
+
+// journalFields returns the fields attached to every entry for the container
+func journalFields(config *Config) map[string]string {
+	return map[string]string{
+		"SYSLOG_IDENTIFIER": config.ID,
+		"CONTAINER_ID":      config.ID,
+		"CONTAINER_NAME":    config.ID,
+		"NAMESPACE":         config.Namespace,
+		"IMAGE":             config.Image,
+		"SCOPE":             config.Scope,
+	}
+}
+
+// Stream returns a writer that sends each line written as a journal entry
+func (j *journal) Stream(name string, priority int) io.Writer {
+	w := newLineWriter(func(line []byte) {
+		j.emit(string(line), name, priority)
+	})
+	j.mu.Lock()
+	j.streams = append(j.streams, w)
+	j.mu.Unlock()
+	return w
+}
+
+// emit sends the entry, counting it as dropped when the journal cannot be
+// reached and reporting the count with the next entry that is sent
+func (j *journal) emit(message, stream string, priority int) {
+	if n := atomic.LoadUint64(&j.dropped); n > 0 {
+		if err := j.send(fmt.Sprintf("containerd-proxy dropped %d messages", n), "proxy", 4); err == nil {
+			atomic.AddUint64(&j.dropped, ^(n - 1))
+		}
+	}
+	if err := j.send(message, stream, priority); err != nil {
+		atomic.AddUint64(&j.dropped, 1)
+	}
+}
+
+func (j *journal) send(message, stream string, priority int) error {
+	var data bytes.Buffer
+	writeJournalField(&data, "MESSAGE", message)
+	writeJournalField(&data, "PRIORITY", strconv.Itoa(priority))
+	writeJournalField(&data, "STREAM", stream)
+	for k, v := range j.fields {
+		if v == "" {
+			continue
+		}
+		writeJournalField(&data, k, v)
+	}
+	_, _, err := j.conn.WriteMsgUnix(data.Bytes(), nil, j.addr)
+	if err == nil || !isMessageTooLarge(err) {
+		return err
+	}
+	// large entries are passed to the journal through an unlinked file
+	f, err := ioutil.TempFile("/dev/shm", "containerd-proxy-journal")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := data.WriteTo(f); err != nil {
+		return err
+	}
+	_, _, err = j.conn.WriteMsgUnix(nil, unix.UnixRights(int(f.Fd())), j.addr)
+	return err
+}
+
+func (j *journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, s := range j.streams {
+		s.Close()
+	}
+	return j.conn.Close()
+}
+
+// writeJournalField encodes a field using the journal's native protocol,
+// values with newlines use the explicit length form
+func writeJournalField(w *bytes.Buffer, key, value string) {
+	if !strings.ContainsRune(value, '\n') {
+		w.WriteString(key)
+		w.WriteByte('=')
+		w.WriteString(value)
+		w.WriteByte('\n')
+		return
+	}
+	w.WriteString(key)
+	w.WriteByte('\n')
+	binary.Write(w, binary.LittleEndian, uint64(len(value)))
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+func isMessageTooLarge(err error) bool {
+	if oerr, ok := err.(*net.OpError); ok {
+		if serr, ok := oerr.Err.(*os.SyscallError); ok {
+			err = serr.Err
+		}
+	}
+	return err == syscall.EMSGSIZE || err == syscall.ENOBUFS
+}
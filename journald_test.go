@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "socket")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{
+		Name: socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	j, err := newJournal(socket, journalFields(&Config{
+		ID:        "redis",
+		Namespace: "services",
+		Image:     "docker.io/library/redis:latest",
+		Scope:     "ce",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := j.Stream("stdout", stdoutPriority), j.Stream("stderr", stderrPriority)
+	if _, err := stdout.Write([]byte("ready to accept\nconnections")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stderr.Write([]byte("warning\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]string{
+		{"MESSAGE": "ready to accept", "PRIORITY": "6", "STREAM": "stdout"},
+		{"MESSAGE": "warning", "PRIORITY": "3", "STREAM": "stderr"},
+		{"MESSAGE": "connections", "PRIORITY": "6", "STREAM": "stdout"},
+	}
+	buf := make([]byte, 4096)
+	for i, e := range expected {
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := parseJournalEntry(t, buf[:n])
+		e["CONTAINER_ID"] = "redis"
+		e["CONTAINER_NAME"] = "redis"
+		e["NAMESPACE"] = "services"
+		e["IMAGE"] = "docker.io/library/redis:latest"
+		e["SCOPE"] = "ce"
+		for k, v := range e {
+			if fields[k] != v {
+				t.Errorf("%d: expected %s=%q but got %q", i, k, v, fields[k])
+			}
+		}
+	}
+}
+
+func TestJournalDropped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "socket")
+	j, err := newJournal(socket, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	stdout := j.Stream("stdout", stdoutPriority)
+	data := []byte("one\ntwo\n")
+	if n, err := stdout.Write(data); err != nil || n != len(data) {
+		t.Fatalf("expected write to succeed without a journal but got %d, %v", n, err)
+	}
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{
+		Name: socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if _, err := stdout.Write([]byte("three\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	for _, message := range []string{"containerd-proxy dropped 2 messages", "three"} {
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields := parseJournalEntry(t, buf[:n]); fields["MESSAGE"] != message {
+			t.Errorf("expected message %q but got %q", message, fields["MESSAGE"])
+		}
+	}
+}
+
+func TestJournalMultilineField(t *testing.T) {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", "one\ntwo")
+	fields := parseJournalEntry(t, buf.Bytes())
+	if fields["MESSAGE"] != "one\ntwo" {
+		t.Errorf("expected multiline message but got %q", fields["MESSAGE"])
+	}
+}
+
+func parseJournalEntry(t *testing.T, data []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "=\n")
+		if i < 0 {
+			t.Fatalf("invalid journal entry %q", data)
+		}
+		key := string(data[:i])
+		if data[i] == '=' {
+			data = data[i+1:]
+			end := bytes.IndexByte(data, '\n')
+			fields[key] = string(data[:end])
+			data = data[end+1:]
+			continue
+		}
+		data = data[i+1:]
+		size := binary.LittleEndian.Uint64(data[:8])
+		data = data[8:]
+		fields[key] = string(data[:size])
+		data = data[size+1:]
+	}
+	return fields
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultsName is the config in the config directory merged beneath every
+// container's config
+const defaultsName = "defaults"
+
+// configLayers returns the files merged for the id's config in order: the
+// global defaults, the id's config and its drop-ins in lexical order
+func configLayers(dir, id string) ([]string, error) {
+	defaults := configPaths(dir, defaultsName)
+	if len(defaults) > 1 {
+		return nil, fmt.Errorf("multiple defaults configs %s", strings.Join(defaults, ", "))
+	}
+	paths := configPaths(dir, id)
+	switch len(paths) {
+	case 0:
+		return nil, fmt.Errorf("no config for %s in %s", id, dir)
+	case 1:
+	default:
+		return nil, fmt.Errorf("multiple configs for %s: %s", id, strings.Join(paths, ", "))
+	}
+	files := append(defaults, paths[0])
+	dropins, err := filepath.Glob(filepath.Join(paths[0]+".d", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dropins {
+		if isConfigExtension(filepath.Ext(d)) {
+			files = append(files, d)
+		}
+	}
+	return files, nil
+}
+
+// opaqueFields are replaced as a whole by higher layers rather than merged,
+// the spec patch may use null values of its own
+var opaqueFields = map[string]bool{
+	"$.specPatch": true,
+}
+
+// readLayers merges the config layers for id. Objects are merged key by key,
+// other values replace the lower layer's value, a null removes the value and a
+// list field suffixed with + appends to the lower layer's list. The file that
+// set each value is returned keyed by its JSON path
+func readLayers(dir, id string) (map[string]interface{}, map[string]string, validationErrors, error) {
+	files, err := configLayers(dir, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var (
+		errs    validationErrors
+		merged  = make(map[string]interface{})
+		sources = make(map[string]string)
+		t       = reflect.TypeOf(Config{})
+	)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		layer, err := decodeLayer(filepath.Ext(file), data)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for _, e := range unknownFields(layer, t, "$") {
+			e.File = file
+			errs = append(errs, e)
+		}
+		if err := mergeLayer(merged, layer, "$", file, sources); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return merged, sources, errs, nil
+}
+
+func mergeLayer(dst, src map[string]interface{}, path, file string, sources map[string]string) error {
+	var keys []string
+	for k := range src {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := src[k]
+		if strings.HasSuffix(k, "+") {
+			name := strings.TrimSuffix(k, "+")
+			list, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s.%s: only lists can be appended to", path, k)
+			}
+			existing, _ := dst[name].([]interface{})
+			for i, e := range list {
+				recordSources(e, fmt.Sprintf("%s.%s[%d]", path, name, len(existing)+i), file, sources)
+			}
+			dst[name] = append(existing, list...)
+			continue
+		}
+		p := path + "." + k
+		if opaqueFields[p] && v != nil {
+			clearSources(p, sources)
+			dst[k] = v
+			recordSources(v, p, file, sources)
+			continue
+		}
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				if err := mergeLayer(dm, sm, p, file, sources); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		clearSources(p, sources)
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		dst[k] = v
+		recordSources(v, p, file, sources)
+	}
+	return nil
+}
+
+func recordSources(v interface{}, path, file string, sources map[string]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			recordSources(e, path+"."+k, file, sources)
+		}
+	case []interface{}:
+		for i, e := range t {
+			recordSources(e, fmt.Sprintf("%s[%d]", path, i), file, sources)
+		}
+	default:
+		sources[path] = file
+	}
+}
+
+func clearSources(path string, sources map[string]string) {
+	for p := range sources {
+		if p == path || strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			delete(sources, p)
+		}
+	}
+}
+
+// configCommand handles `config show <id>` printing the effective config and
+// the file each value came from
+func configCommand(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	if len(args) != 2 || args[0] != "show" {
+		return errors.New("usage: config show <id>")
+	}
+	id := args[1]
+	merged, sources, errs, err := readLayers(configDir, id)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n\n", data)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	printSources(w, merged, "$", sources)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func printSources(w *tabwriter.Writer, v interface{}, path string, sources map[string]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		var keys []string
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			printSources(w, t[k], path+"."+k, sources)
+		}
+	case []interface{}:
+		for i, e := range t {
+			printSources(w, e, fmt.Sprintf("%s[%d]", path, i), sources)
+		}
+	default:
+		fmt.Fprintf(w, "%s\t%s\n", path, sources[path])
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dropins := configPath(dir, "redis") + ".d"
+	if err := os.MkdirAll(dropins, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		configPath(dir, defaultsName):            `{"namespace":"services","scope":"ce","args":["--verbose"],"logging":{"driver":"file","maxSize":1024}}`,
+		configPath(dir, "redis"):                 `{"image":"docker.io/library/redis:4","args+":["--port","6380"]}`,
+		filepath.Join(dropins, "10-image.json"):  `{"image":"docker.io/library/redis:5","logging":{"maxFiles":3}}`,
+		filepath.Join(dropins, "20-scope.json"):  `{"scope":null,"logging":{"maxSize":2048}}`,
+		filepath.Join(dropins, "ignored.config"): `{"image":"ignored"}`,
+	}
+	for p, data := range files {
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, errs, err := checkConfig(dir, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	expected := &Config{
+		ID:        "redis",
+		Namespace: "services",
+		Image:     "docker.io/library/redis:5",
+		Args:      []string{"--verbose", "--port", "6380"},
+		Logging: &Logging{
+			Driver:   FileDriver,
+			MaxSize:  2048,
+			MaxFiles: 3,
+		},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected %+v but got %+v", expected, c)
+	}
+
+	_, sources, _, err := readLayers(dir, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, file := range map[string]string{
+		"$.namespace":        configPath(dir, defaultsName),
+		"$.args[0]":          configPath(dir, defaultsName),
+		"$.args[1]":          configPath(dir, "redis"),
+		"$.image":            filepath.Join(dropins, "10-image.json"),
+		"$.logging.driver":   configPath(dir, defaultsName),
+		"$.logging.maxSize":  filepath.Join(dropins, "20-scope.json"),
+		"$.logging.maxFiles": filepath.Join(dropins, "10-image.json"),
+	} {
+		if sources[path] != file {
+			t.Errorf("expected %s to come from %s but got %s", path, file, sources[path])
+		}
+	}
+	if _, ok := sources["$.scope"]; ok {
+		t.Error("expected removed scope to have no source")
+	}
+}
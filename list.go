@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+)
+
+type serviceEntry struct {
+	ID              string   `json:"id"`
+	Namespace       string   `json:"namespace"`
+	Image           string   `json:"image,omitempty"`
+	ContainerImage  string   `json:"containerImage,omitempty"`
+	Scope           string   `json:"scope,omitempty"`
+	Status          string   `json:"status"`
+	Drift           []string `json:"drift,omitempty"`
+	containerExists bool
+}
+
+// list prints every configured service and proxied container on the host
+func list(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	var (
+		fs     = flag.NewFlagSet("list", flag.ContinueOnError)
+		asJSON = fs.Bool("json", false, "print the services as json")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	entries, err := listServices(ctx, client, configDir)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAMESPACE\tIMAGE\tRUNNING IMAGE\tSCOPE\tSTATUS\tDRIFT")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID, e.Namespace, dash(e.Image), dash(e.ContainerImage), dash(e.Scope), e.Status, dash(strings.Join(e.Drift, ",")))
+	}
+	return w.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// listServices joins the configs in dir against the proxied containers in
+// every namespace
+func listServices(ctx context.Context, client *containerd.Client, dir string) ([]*serviceEntry, error) {
+	ids, err := configIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		entries = make(map[string]*serviceEntry)
+		// an invalid config has no namespace so its container is matched by
+		// id in any namespace
+		invalid = make(map[string]*serviceEntry)
+		key     = func(namespace, id string) string {
+			return namespace + "/" + id
+		}
+	)
+	for _, id := range ids {
+		c, err := loadConfigFrom(dir, id)
+		if err != nil {
+			invalid[id] = &serviceEntry{
+				ID:     id,
+				Status: "invalid config: " + err.Error(),
+			}
+			continue
+		}
+		e := &serviceEntry{
+			ID:        id,
+			Namespace: c.Namespace,
+			Image:     c.Image,
+			Scope:     c.Scope,
+		}
+		entries[key(c.Namespace, id)] = e
+		// the container is loaded by id as it may not have a scope label
+		nctx := namespaces.WithNamespace(ctx, c.Namespace)
+		container, err := client.LoadContainer(nctx, id)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if err := e.join(nctx, container); err != nil {
+			return nil, err
+		}
+	}
+	// find the proxied containers that have no config
+	nss, err := client.NamespaceService().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range nss {
+		nctx := namespaces.WithNamespace(ctx, ns)
+		containers, err := client.Containers(nctx, fmt.Sprintf("labels.%q", ScopeLabel))
+		if err != nil {
+			return nil, err
+		}
+		for _, container := range containers {
+			if _, ok := entries[key(ns, container.ID())]; ok {
+				continue
+			}
+			e, ok := invalid[container.ID()]
+			if ok && !e.containerExists {
+				e.Namespace = ns
+			} else {
+				e = &serviceEntry{
+					ID:        container.ID(),
+					Namespace: ns,
+					Drift:     []string{"no config"},
+				}
+			}
+			entries[key(ns, container.ID())] = e
+			if err := e.join(nctx, container); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, e := range invalid {
+		if !e.containerExists {
+			entries[key("", e.ID)] = e
+		}
+	}
+	var out []*serviceEntry
+	for _, e := range entries {
+		if !e.containerExists && e.Status == "" {
+			e.Status = "not created"
+			e.Drift = append(e.Drift, "no container")
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Namespace != out[j].Namespace {
+			return out[i].Namespace < out[j].Namespace
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// join records the container's state on the entry and any drift from its
+// config, the status of an invalid config is kept
+func (e *serviceEntry) join(ctx context.Context, container containerd.Container) error {
+	info, err := container.Info(ctx)
+	if err != nil {
+		return err
+	}
+	e.containerExists = true
+	e.ContainerImage = info.Image
+	if e.Image != "" && normalizeImage(e.Image) != normalizeImage(info.Image) {
+		e.Drift = append(e.Drift, "image")
+	}
+	if scope := info.Labels[ScopeLabel]; e.Scope == "" {
+		e.Scope = scope
+	} else if scope != e.Scope {
+		e.Drift = append(e.Drift, "scope")
+	}
+	state, err := taskState(ctx, container)
+	if err != nil {
+		return err
+	}
+	if e.Status == "" {
+		e.Status = state
+	}
+	return nil
+}
+
+func taskState(ctx context.Context, container containerd.Container) (string, error) {
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return "stopped", nil
+		}
+		return "", err
+	}
+	s, err := task.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	return string(s.Status), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListServices(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+
+	dir, err := ioutil.TempDir("", "containerd-proxy-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for id, config := range map[string]string{
+		"redis":     `{"namespace":"services","image":"docker.io/library/redis:4","scope":"prod"}`,
+		"memcached": `{"namespace":"services","image":"docker.io/library/memcached:1.5"}`,
+		"nginx":     `{"namespace":"services","image":"docker.io/library/nginx:1.15","scope":"prod"}`,
+		"broken":    `{"namespace":`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, id+".json"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.add("services", "redis", "docker.io/library/redis:4", map[string]string{
+		ScopeLabel: "prod",
+	}, true)
+	// created without a scope label so it is only found by id
+	f.add("services", "memcached", "docker.io/library/memcached:1.4", nil, false)
+	f.add("services", "orphan", "docker.io/library/alpine:3.7", map[string]string{
+		ScopeLabel: "prod",
+	}, true)
+	f.add("other", "broken", "docker.io/library/busybox:latest", map[string]string{
+		ScopeLabel: "",
+	}, false)
+	f.start(t)
+
+	client := f.client(t)
+	defer client.Close()
+
+	entries, err := listServices(context.Background(), client, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var invalid *serviceEntry
+	for _, e := range entries {
+		if e.ID == "broken" {
+			invalid = e
+			e.Status = strings.SplitN(e.Status, ":", 2)[0]
+		}
+	}
+	if invalid == nil {
+		t.Fatal("expected the invalid config to be listed")
+	}
+	expected := []*serviceEntry{
+		{
+			ID:              "broken",
+			Namespace:       "other",
+			ContainerImage:  "docker.io/library/busybox:latest",
+			Status:          "invalid config",
+			containerExists: true,
+		},
+		{
+			ID:              "memcached",
+			Namespace:       "services",
+			Image:           "docker.io/library/memcached:1.5",
+			ContainerImage:  "docker.io/library/memcached:1.4",
+			Status:          "stopped",
+			Drift:           []string{"image"},
+			containerExists: true,
+		},
+		{
+			ID:        "nginx",
+			Namespace: "services",
+			Image:     "docker.io/library/nginx:1.15",
+			Scope:     "prod",
+			Status:    "not created",
+			Drift:     []string{"no container"},
+		},
+		{
+			ID:              "orphan",
+			Namespace:       "services",
+			ContainerImage:  "docker.io/library/alpine:3.7",
+			Scope:           "prod",
+			Status:          "running",
+			Drift:           []string{"no config"},
+			containerExists: true,
+		},
+		{
+			ID:              "redis",
+			Namespace:       "services",
+			Image:           "docker.io/library/redis:4",
+			ContainerImage:  "docker.io/library/redis:4",
+			Scope:           "prod",
+			Status:          "running",
+			containerExists: true,
+		},
+	}
+	if len(entries) != len(expected) {
+		for _, e := range entries {
+			t.Logf("%+v", e)
+		}
+		t.Fatalf("expected %d entries but got %d", len(expected), len(entries))
+	}
+	for i, e := range entries {
+		if !reflect.DeepEqual(e, expected[i]) {
+			t.Errorf("expected %+v but got %+v", expected[i], e)
+		}
+	}
+}
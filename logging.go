@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/containerd/containerd/cio"
+)
+
+const (
+	PassthroughDriver = "passthrough"
+	FileDriver        = "file"
+
+	defaultLogDir = "/var/log/containerd-proxy"
+)
+
+// Logging configures where the container's stdout and stderr are written.
+// The task's output is copied by the proxy, containerd 1.1 has no shim side
+// logging, so while the proxy is not running the output is not logged and the
+// task blocks once its pipes are full
+type Logging struct {
+	Driver   string   `json:"driver"`
+	Path     string   `json:"path"`
+	MaxSize  int64    `json:"maxSize"`
+	MaxAge   Duration `json:"maxAge"`
+	MaxFiles int      `json:"maxFiles"`
+	Compress bool     `json:"compress"`
+	Socket   string   `json:"socket"`
+
+	// syslog driver settings
+	Address    string     `json:"address"`
+	Facility   string     `json:"facility"`
+	Tag        string     `json:"tag"`
+	TLS        *SyslogTLS `json:"tls"`
+	BufferSize int        `json:"bufferSize"`
+}
+
+func (l *Logging) driver() string {
+	if l == nil || l.Driver == "" {
+		return PassthroughDriver
+	}
+	return l.Driver
+}
+
+func (l *Logging) file(id string) string {
+	if l.Path != "" {
+		return l.Path
+	}
+	return filepath.Join(defaultLogDir, id+".log")
+}
+
+func (l *Logging) validate(path string) validationErrors {
+	var errs validationErrors
+	switch l.driver() {
+	case PassthroughDriver, JournaldDriver:
+	case FileDriver:
+		if l.MaxSize < 0 {
+			errs.add(path+".maxSize", "must not be negative")
+		}
+		if l.MaxAge.Duration < 0 {
+			errs.add(path+".maxAge", "must not be negative")
+		}
+		if l.MaxFiles < 0 {
+			errs.add(path+".maxFiles", "must not be negative")
+		}
+	case SyslogDriver:
+		if l.Address != "" {
+			if u, err := url.Parse(l.Address); err != nil {
+				errs.add(path+".address", "%v", err)
+			} else {
+				switch u.Scheme {
+				case "unix", "unixgram", "udp", "tcp", "tcp+tls":
+				default:
+					errs.add(path+".address", "unsupported scheme %q", u.Scheme)
+				}
+			}
+		}
+		if _, ok := syslogFacilities[l.Facility]; l.Facility != "" && !ok {
+			errs.add(path+".facility", "unknown syslog facility %q", l.Facility)
+		}
+		if _, err := template.New("tag").Parse(l.Tag); err != nil {
+			errs.add(path+".tag", "%v", err)
+		}
+		if l.BufferSize < 0 {
+			errs.add(path+".bufferSize", "must not be negative")
+		}
+	default:
+		errs.add(path+".driver", "unknown log driver %q", l.Driver)
+	}
+	return errs
+}
+
+// newLogDriver returns the writers for the task's stdout and stderr according
+// to the configured log driver along with a closer to release its resources
+func newLogDriver(config *Config) (io.Writer, io.Writer, io.Closer, error) {
+	l := config.Logging
+	switch l.driver() {
+	case PassthroughDriver:
+		return os.Stdout, os.Stderr, nopCloser{}, nil
+	case FileDriver:
+		f, err := newRotatingFile(l.file(config.ID), l.MaxSize, l.MaxAge.Duration, l.MaxFiles, l.Compress)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return f, f, f, nil
+	case JournaldDriver:
+		socket := l.Socket
+		if socket == "" {
+			socket = defaultJournalSocket
+		}
+		j, err := newJournal(socket, journalFields(config))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return j.Stream("stdout", stdoutPriority), j.Stream("stderr", stderrPriority), j, nil
+	case SyslogDriver:
+		s, err := newSyslog(config)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return s.Stream("stdout", stdoutPriority), s.Stream("stderr", stderrPriority), s, nil
+	}
+	return nil, nil, nil, fmt.Errorf("unknown log driver %q", l.Driver)
+}
+
+// taskLogger routes the task's output to the configured log driver, the
+// driver can be replaced while the task is running
+type taskLogger struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+	closer io.Closer
+	closed bool
+}
+
+func newTaskLogger(config *Config) (*taskLogger, error) {
+	l := &taskLogger{}
+	if err := l.setDriver(config); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ioOpts returns the io options for the task writing to the logger
+func (l *taskLogger) ioOpts(terminal bool) []cio.Opt {
+	opts := []cio.Opt{
+		cio.WithStreams(os.Stdin, loggerStream{l: l}, loggerStream{l: l, stderr: true}),
+	}
+	if terminal {
+		opts = append(opts, cio.WithTerminal)
+	}
+	return opts
+}
+
+// setDriver replaces the current log driver with the one configured, the
+// previous driver is closed in the background as flushing it may block
+func (l *taskLogger) setDriver(config *Config) error {
+	stdout, stderr, closer, err := newLogDriver(config)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return closer.Close()
+	}
+	old := l.closer
+	l.stdout, l.stderr, l.closer = stdout, stderr, closer
+	l.mu.Unlock()
+	if old != nil {
+		go func() {
+			if err := old.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "close log driver %s: %v\n", config.ID, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Close detaches the driver before closing it so that output copied after the
+// task has exited is discarded instead of reaching a closed driver
+func (l *taskLogger) Close() error {
+	l.mu.Lock()
+	closer := l.closer
+	l.stdout, l.stderr, l.closer = ioutil.Discard, ioutil.Discard, nopCloser{}
+	l.closed = true
+	l.mu.Unlock()
+	return closer.Close()
+}
+
+type loggerStream struct {
+	l      *taskLogger
+	stderr bool
+}
+
+func (s loggerStream) Write(p []byte) (int, error) {
+	s.l.mu.Lock()
+	defer s.l.mu.Unlock()
+	if s.stderr {
+		return s.l.stderr.Write(p)
+	}
+	return s.l.stdout.Write(p)
+}
+
+// maxLineSize is the longest line buffered before it is emitted without a newline
+const maxLineSize = 16 * 1024
+
+// lineWriter splits writes into lines and emits each one individually, the
+// emitter is responsible for handling lines it fails to send
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func([]byte)
+}
+
+func newLineWriter(emit func([]byte)) *lineWriter {
+	return &lineWriter{
+		emit: emit,
+	}
+}
+
+// Write never fails so that the task's output is always consumed
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLineSize {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Close emits any partial line that is still buffered
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// logs writes the container's persisted log file to stdout
+func logs(ctx context.Context, config *Config, args []string) error {
+	var (
+		fs     = flag.NewFlagSet("logs", flag.ContinueOnError)
+		follow = fs.Bool("f", false, "follow the log output")
+		lines  = fs.Int("n", -1, "number of lines to show from the end of the log")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if config.Logging.driver() != FileDriver {
+		return errors.New("logs are only available with the file log driver")
+	}
+	path := config.Logging.file(config.ID)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+	}()
+	if *lines >= 0 {
+		if err := seekLines(f, *lines); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(os.Stdout, f); err != nil {
+		return err
+	}
+	if !*follow {
+		return nil
+	}
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return err
+		}
+		// reopen the log when it has been rotated underneath us
+		current, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		open, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(current, open) {
+			rotated, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			// drain what was written to the old file before it was rotated
+			if _, err := io.Copy(os.Stdout, f); err != nil {
+				rotated.Close()
+				return err
+			}
+			f.Close()
+			f = rotated
+		}
+	}
+}
+
+// seekLines positions f at the start of the last n lines
+func seekLines(f *os.File, n int) error {
+	var (
+		offsets []int64
+		offset  int64
+		r       = bufio.NewReader(f)
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			offsets = append(offsets, offset)
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case n == 0:
+	case n < len(offsets):
+		offset = offsets[len(offsets)-n]
+	default:
+		offset = 0
+	}
+	_, err := f.Seek(offset, io.SeekStart)
+	return err
+}
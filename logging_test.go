@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestTaskLoggerWriteAfterClose(t *testing.T) {
+	logger, err := newTaskLogger(&Config{
+		ID: "redis",
+		Logging: &Logging{
+			Driver:  SyslogDriver,
+			Address: "udp://127.0.0.1:9",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := (loggerStream{l: logger}).Write([]byte("late\n")); err != nil || n != 5 {
+		t.Errorf("expected late output to be discarded but got %d, %v", n, err)
+	}
+}
@@ -2,105 +2,96 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
-	"time"
+	"strconv"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
-	"github.com/containerd/containerd/defaults"
 	"github.com/containerd/containerd/errdefs"
-	"github.com/containerd/containerd/namespaces"
-	"github.com/crosbymichael/boss/flux"
 	"golang.org/x/sys/unix"
 )
 
 func main() {
-	var (
-		id      = getID()
-		signals = make(chan os.Signal, 64)
-	)
-	config, err := loadConfig(id)
-	if err != nil {
-		exit(err)
-	}
-	ctx := namespaces.WithNamespace(context.Background(), config.Namespace)
+	signals := make(chan os.Signal, 64)
 	signal.Notify(signals)
-	if len(os.Args) == 2 && os.Args[1] == "post-stop" {
-		if err := cleanup(ctx, id); err != nil {
-			exit(err)
-		}
-		return
-	}
-	if err := proxy(ctx, config, signals); err != nil {
-		if eerr, ok := err.(*exitError); ok {
-			os.Exit(eerr.Status)
-		}
+	if err := run(os.Args, signals); err != nil {
 		exit(err)
 	}
 }
 
 func proxy(ctx context.Context, config *Config, signals chan os.Signal) error {
-	client, err := containerd.New(
-		defaults.DefaultAddress,
-		containerd.WithDefaultRuntime("io.containerd.process.v1"),
-		containerd.WithTimeout(1*time.Second),
-	)
+	client, err := newClient()
 	if err != nil {
 		return err
 	}
-	container, err := client.LoadContainer(ctx, config.ID)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// subscribe before the container is prepared so the events for creating
+	// it and its task are seen
+	monitor := newExitMonitor(config.ID)
+	go watchEvents(ctx, subscribe(ctx, client, config), monitor)
+	container, err := prepare(ctx, client, config)
 	if err != nil {
-		if !errdefs.IsNotFound(err) {
-			return err
-		}
-		image, err := config.GetImage(ctx, client)
-		if err != nil {
-			return err
-		}
-		// create new container
-		if container, err = client.NewContainer(ctx, config.ID,
-			WithCurrentSpec(config),
-			flux.WithNewSnapshot(image),
-			WithScope(config.Scope),
-		); err != nil {
-			return err
-		}
+		return err
 	}
-	if err := checkRunning(ctx, container); err != nil {
+	logger, err := newTaskLogger(config)
+	if err != nil {
 		return err
 	}
-	// update container with new spec for current run
-	if err := container.Update(ctx, WithCurrentSpec(config)); err != nil {
+	defer logger.Close()
+	ioOpts := logger.ioOpts(config.Terminal)
+	reload, err := config.reloadSignal()
+	if err != nil {
 		return err
 	}
-	info, err := container.Info(ctx)
+	var keep []os.Signal
+	if config.Terminal {
+		keep = append(keep, unix.SIGWINCH)
+	}
+	filter, err := newSignalFilter(config, append(keep, reload)...)
 	if err != nil {
 		return err
 	}
-	if info.Labels == nil {
-		info.Labels = make(map[string]string)
+	signals = filter.run(ctx, signals)
+	task, err := container.NewTask(ctx, cio.NewCreator(ioOpts...))
+	if err != nil {
+		return err
 	}
-	if config.ShouldUpgrade(info.Image, info.Labels[ScopeLabel]) {
-		image, err := config.GetImage(ctx, client)
-		if err != nil {
+	var con *hostTerminal
+	if config.Terminal {
+		if con, err = newHostTerminal(os.Stdin); err != nil {
+			task.Delete(ctx, containerd.WithProcessKill)
 			return err
 		}
-		if err := container.Update(ctx, flux.WithUpgrade(image), WithScope(config.Scope)); err != nil {
-			return err
+		if con != nil {
+			defer con.Restore()
 		}
 	}
-	task, err := container.NewTask(ctx, cio.NewCreator(cio.WithStdio))
-	if err != nil {
-		return err
-	}
 
 	wait, err := task.Wait(ctx)
 	if err != nil {
-		task.Delete(ctx)
+		task.Delete(ctx, containerd.WithProcessKill)
 		return err
 	}
-	started := make(chan error, 1)
+	vars := map[string]string{
+		"PID": strconv.Itoa(int(task.Pid())),
+	}
+	if err := runHooks(ctx, config, PreStartHook, vars); err != nil {
+		task.Delete(ctx, containerd.WithProcessKill)
+		return err
+	}
+	var (
+		started     = make(chan error, 1)
+		postStarted = make(chan error, 1)
+		reconnected = make(chan reconnection, 1)
+		reloaded    = make(chan *Config, 1)
+		reloading   bool
+		// hookErr is returned instead of the exit status when the task is
+		// killed because a post-start hook failed
+		hookErr error
+	)
 	go func() {
 		started <- task.Start(ctx)
 	}()
@@ -108,34 +99,146 @@ func proxy(ctx context.Context, config *Config, signals chan os.Signal) error {
 		select {
 		case err := <-started:
 			if err != nil {
+				task.Delete(ctx, containerd.WithProcessKill)
+				return err
+			}
+			resize(ctx, con, task, config.ID)
+			recordStart(ctx, container)
+			// post-start hooks run while signals and the task's exit are
+			// still handled
+			go func(config *Config) {
+				postStarted <- runHooks(ctx, config, PostStartHook, vars)
+			}(config)
+		case err := <-postStarted:
+			if err != nil {
+				hookErr = err
+				task.Kill(ctx, unix.SIGKILL)
+				continue
+			}
+			notifyReady()
+		case r := <-reconnected:
+			if r.err != nil {
+				unix.Kill(int(task.Pid()), unix.SIGKILL)
+				return r.err
+			}
+			client.Close()
+			client, task = r.client, r.task
+			go watchEvents(ctx, subscribe(ctx, client, config), monitor)
+			if wait, err = task.Wait(ctx); err != nil {
 				return err
 			}
 		case s := <-signals:
-			if s == unix.SIGCONT {
+			// window changes are only propagated, never forwarded, when the
+			// task has a terminal
+			if s == unix.SIGWINCH && config.Terminal {
+				resize(ctx, con, task, config.ID)
+				continue
+			}
+			if reload != nil && s == reload {
+				// the config is reloaded off the loop as replacing the log
+				// driver may block, signals and exits are handled meanwhile
+				if !reloading {
+					reloading = true
+					go func(current *Config) {
+						reloaded <- reloadConfig(current, logger, filter, keep...)
+					}(config)
+				}
 				continue
 			}
 			if err := trySendSignal(ctx, client, task, s); err != nil {
 				return err
 			}
+		case next := <-reloaded:
+			reloading = false
+			config = next
+			reload, _ = config.reloadSignal()
 		case exit := <-wait:
-			if exit.Error() != nil {
+			if err := exit.Error(); err != nil {
 				if !isUnavailable(err) {
 					unix.Kill(int(task.Pid()), unix.SIGKILL)
 					return err
 				}
-				if client, task, err = reconnect(ctx, config.ID); err != nil {
-					unix.Kill(int(task.Pid()), unix.SIGKILL)
-					return err
-				}
-				if wait, err = task.Wait(ctx); err != nil {
-					return err
-				}
+				fmt.Fprintf(os.Stderr, "proxy %s: lost connection to containerd: %v\n", config.ID, err)
+				// stop waiting until the task is attached again, signals are
+				// still sent to the pid while containerd is down
+				wait = nil
+				go func(config *Config) {
+					c, t, err := reconnect(ctx, config, ioOpts)
+					reconnected <- reconnection{client: c, task: t, err: err}
+				}(config)
 				continue
 			}
+			status, cause := exitStatus(config, exit.ExitCode(), monitor.oomKilled(exitEventTimeout))
+			fmt.Fprintf(os.Stderr, "proxy %s: task %s\n", config.ID, cause)
 			task.Delete(ctx)
+			recordExit(ctx, client, config.ID, status, exit.ExitTime())
+			if hookErr != nil {
+				return hookErr
+			}
 			return &exitError{
-				Status: int(exit.ExitCode()),
+				Status: status,
 			}
 		}
 	}
 }
+
+// prepare loads or creates the container and updates its spec and image to
+// match the config before a new task is created
+func prepare(ctx context.Context, client *containerd.Client, config *Config) (containerd.Container, error) {
+	container, err := client.LoadContainer(ctx, config.ID)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		image, err := config.GetImage(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		if err := runHooks(ctx, config, PreCreateHook, nil); err != nil {
+			return nil, err
+		}
+		// create new container, the snapshot is created first so that the
+		// spec can be resolved against the rootfs
+		if container, err = client.NewContainer(ctx, config.ID,
+			withNewSnapshot(config, image),
+			WithCurrentSpec(config),
+			WithScope(config.Scope),
+		); err != nil {
+			return nil, err
+		}
+		if err := unpinImages(ctx, client, image.Name()); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkRunning(ctx, container); err != nil {
+		return nil, err
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.Labels == nil {
+		info.Labels = make(map[string]string)
+	}
+	if config.ShouldUpgrade(info.Image, info.Labels[ScopeLabel]) {
+		image, err := config.GetImage(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		if err := upgradeContainer(ctx, client, config, container, info, image); err != nil {
+			return nil, err
+		}
+	} else {
+		// no snapshot is taken when the fetched image is already in use so
+		// the pin is released here
+		if err := unpinImages(ctx, client, info.Image, normalizeImage(config.Image)); err != nil {
+			return nil, err
+		}
+	}
+	// update container with new spec for current run after any upgrade so
+	// that it is resolved against the current rootfs
+	if err := container.Update(ctx, checkUserns(config), WithCurrentSpec(config)); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
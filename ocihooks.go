@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// OCIHooks are added to the container's runtime spec and run by the runtime,
+// startContainer hooks run in the container so their paths are inside the
+// container, every other hook's path is on the host even though
+// createContainer hooks run in the container's namespaces
+type OCIHooks struct {
+	Prestart        []OCIHook `json:"prestart"`
+	CreateRuntime   []OCIHook `json:"createRuntime"`
+	CreateContainer []OCIHook `json:"createContainer"`
+	StartContainer  []OCIHook `json:"startContainer"`
+	Poststart       []OCIHook `json:"poststart"`
+	Poststop        []OCIHook `json:"poststop"`
+}
+
+// OCIHook is a runtime hook, the timeout is rounded up to whole seconds
+type OCIHook struct {
+	Path    string   `json:"path"`
+	Args    []string `json:"args"`
+	Env     []string `json:"env"`
+	Timeout Duration `json:"timeout"`
+}
+
+func (h OCIHook) spec() specs.Hook {
+	s := specs.Hook{
+		Path: h.Path,
+		Args: h.Args,
+		Env:  h.Env,
+	}
+	if h.Timeout.Duration > 0 {
+		t := int(math.Ceil(h.Timeout.Seconds()))
+		s.Timeout = &t
+	}
+	return s
+}
+
+func (h *OCIHooks) validate(path string) validationErrors {
+	var errs validationErrors
+	for _, group := range []struct {
+		name  string
+		hooks []OCIHook
+		host  bool
+	}{
+		{"prestart", h.Prestart, true},
+		{"createRuntime", h.CreateRuntime, true},
+		{"createContainer", h.CreateContainer, true},
+		{"startContainer", h.StartContainer, false},
+		{"poststart", h.Poststart, true},
+		{"poststop", h.Poststop, true},
+	} {
+		for i, hook := range group.hooks {
+			p := fmt.Sprintf("%s.%s[%d]", path, group.name, i)
+			switch {
+			case !filepath.IsAbs(hook.Path):
+				errs.add(p+".path", "%q is not an absolute path", hook.Path)
+			case group.host:
+				if err := checkExecutable(hook.Path); err != nil {
+					errs.add(p+".path", "%v", err)
+				}
+			}
+			for j, e := range hook.Env {
+				if !strings.Contains(e, "=") {
+					errs.add(fmt.Sprintf("%s.env[%d]", p, j), "must be in the form KEY=value")
+				}
+			}
+			if hook.Timeout.Duration < 0 {
+				errs.add(p+".timeout", "must not be negative")
+			}
+		}
+	}
+	return errs
+}
+
+func checkExecutable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if fi.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// withOCIHooks appends the configured hooks to the spec's hooks
+func withOCIHooks(h *OCIHooks) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Hooks == nil {
+			s.Hooks = &specs.Hooks{}
+		}
+		for _, group := range []struct {
+			dst   *[]specs.Hook
+			hooks []OCIHook
+		}{
+			{&s.Hooks.Prestart, h.Prestart},
+			{&s.Hooks.CreateRuntime, h.CreateRuntime},
+			{&s.Hooks.CreateContainer, h.CreateContainer},
+			{&s.Hooks.StartContainer, h.StartContainer},
+			{&s.Hooks.Poststart, h.Poststart},
+			{&s.Hooks.Poststop, h.Poststop},
+		} {
+			for _, hook := range group.hooks {
+				*group.dst = append(*group.dst, hook.spec())
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestWithOCIHooks(t *testing.T) {
+	timeout := 2
+	s := &oci.Spec{
+		Hooks: &specs.Hooks{
+			Prestart: []specs.Hook{{Path: "/usr/bin/existing"}},
+		},
+	}
+	hooks := &OCIHooks{
+		Prestart: []OCIHook{
+			{Path: "/usr/local/bin/netns", Args: []string{"netns", "setup"}, Env: []string{"BRIDGE=br0"}},
+		},
+		StartContainer: []OCIHook{
+			{Path: "/sbin/ldconfig", Timeout: Duration{1500 * time.Millisecond}},
+		},
+		Poststop: []OCIHook{
+			{Path: "/usr/local/bin/netns", Args: []string{"netns", "teardown"}},
+		},
+	}
+	if err := withOCIHooks(hooks)(context.Background(), nil, &containers.Container{}, s); err != nil {
+		t.Fatal(err)
+	}
+	expected := &specs.Hooks{
+		Prestart: []specs.Hook{
+			{Path: "/usr/bin/existing"},
+			{Path: "/usr/local/bin/netns", Args: []string{"netns", "setup"}, Env: []string{"BRIDGE=br0"}},
+		},
+		StartContainer: []specs.Hook{
+			{Path: "/sbin/ldconfig", Timeout: &timeout},
+		},
+		Poststop: []specs.Hook{
+			{Path: "/usr/local/bin/netns", Args: []string{"netns", "teardown"}},
+		},
+	}
+	if !reflect.DeepEqual(s.Hooks, expected) {
+		t.Errorf("expected %+v but got %+v", expected, s.Hooks)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/typeurl"
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// patchSpec applies a json merge patch (RFC 7386) when patch is an object or
+// a json patch (RFC 6902) when patch is a list of operations to the spec
+func patchSpec(s *oci.Spec, patch json.RawMessage) (*oci.Spec, error) {
+	doc, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	switch patchKind(patch) {
+	case '{':
+		if doc, err = jsonpatch.MergePatch(doc, patch); err != nil {
+			return nil, fmt.Errorf("spec merge patch: %v", err)
+		}
+	case '[':
+		p, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("spec json patch: %v", err)
+		}
+		if doc, err = p.Apply(doc); err != nil {
+			return nil, fmt.Errorf("spec json patch: %v", err)
+		}
+	default:
+		return nil, errors.New("spec patch must be a merge patch object or a list of json patch operations")
+	}
+	var patched oci.Spec
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return nil, fmt.Errorf("patched spec: %v", err)
+	}
+	return &patched, nil
+}
+
+func patchKind(patch json.RawMessage) byte {
+	if p := bytes.TrimSpace(patch); len(p) > 0 {
+		return p[0]
+	}
+	return 0
+}
+
+func validateSpecPatch(patch json.RawMessage, path string) validationErrors {
+	var errs validationErrors
+	switch patchKind(patch) {
+	case '{':
+	case '[':
+		if _, err := jsonpatch.DecodePatch(patch); err != nil {
+			errs.add(path, "%v", err)
+		}
+	default:
+		errs.add(path, "must be a merge patch object or a list of json patch operations")
+	}
+	return errs
+}
+
+// specCommand prints the spec the container would be run with, the existing
+// container's rootfs is used to resolve the user when it has been created
+func specCommand(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var (
+		c = &containers.Container{
+			ID: config.ID,
+		}
+		unresolved string
+	)
+	container, err := client.LoadContainer(ctx, config.ID)
+	switch {
+	case err == nil:
+		info, err := container.Info(ctx)
+		if err != nil {
+			return err
+		}
+		c = &info
+	case !errdefs.IsNotFound(err):
+		return err
+	case config.User != "" || len(config.Groups) > 0:
+		// the container has no rootfs yet so the user is resolved against a
+		// view of the image, without the image it is shown as configured
+		remove, err := viewImage(ctx, client, config, c)
+		switch {
+		case err == nil:
+			defer remove()
+		case errdefs.IsNotFound(err):
+			fmt.Fprintf(os.Stderr, "%s has not been fetched, the user and groups are not resolved\n", config.Image)
+			copied := *config
+			copied.User, copied.Groups = "", nil
+			unresolved, config = config.User, &copied
+		default:
+			return err
+		}
+	}
+	if err := WithCurrentSpec(config)(ctx, client, c); err != nil {
+		return err
+	}
+	v, err := typeurl.UnmarshalAny(c.Spec)
+	if err != nil {
+		return err
+	}
+	if s, ok := v.(*oci.Spec); ok && s.Process != nil && unresolved != "" {
+		s.Process.User.Username = unresolved
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
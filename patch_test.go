@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/typeurl"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func testSpec() *oci.Spec {
+	return &oci.Spec{
+		Version:  specs.Version,
+		Hostname: "redis",
+		Process: &specs.Process{
+			Args:            []string{"redis"},
+			Cwd:             "/",
+			NoNewPrivileges: true,
+		},
+		Root: &specs.Root{
+			Path: "rootfs",
+		},
+	}
+}
+
+var specPatches = []struct {
+	name  string
+	patch string
+	check func(*oci.Spec) bool
+	err   string
+}{
+	{
+		name:  "merge patch",
+		patch: `{"hostname":"cache","process":{"noNewPrivileges":null,"cwd":"/data"}}`,
+		check: func(s *oci.Spec) bool {
+			return s.Hostname == "cache" && !s.Process.NoNewPrivileges && s.Process.Cwd == "/data" && s.Process.Args[0] == "redis"
+		},
+	},
+	{
+		name:  "json patch",
+		patch: `[{"op":"add","path":"/process/args/-","value":"--appendonly"},{"op":"replace","path":"/root/readonly","value":true}]`,
+		check: func(s *oci.Spec) bool {
+			return len(s.Process.Args) == 2 && s.Process.Args[1] == "--appendonly" && s.Root.Readonly
+		},
+	},
+	{
+		name:  "failed operation",
+		patch: `[{"op":"test","path":"/hostname","value":"other"}]`,
+		err:   "spec json patch",
+	},
+	{
+		name:  "unknown spec field",
+		patch: `{"process":{"noNewPrivilege":false}}`,
+		err:   `patched spec: json: unknown field "noNewPrivilege"`,
+	},
+	{
+		name:  "not a patch",
+		patch: `"hostname"`,
+		err:   "spec patch must be",
+	},
+}
+
+func TestPatchSpec(t *testing.T) {
+	for _, tc := range specPatches {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := patchSpec(testSpec(), json.RawMessage(tc.patch))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q but got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(s) {
+				t.Errorf("unexpected spec %+v", s.Process)
+			}
+		})
+	}
+}
+
+func TestSpecPatchAfterOptions(t *testing.T) {
+	config := &Config{
+		ID:        "redis",
+		Terminal:  true,
+		SpecPatch: json.RawMessage(`{"process":{"terminal":false}}`),
+	}
+	c := &containers.Container{ID: config.ID}
+	ctx := namespaces.WithNamespace(context.Background(), "services")
+	if err := WithCurrentSpec(config)(ctx, nil, c); err != nil {
+		t.Fatal(err)
+	}
+	v, err := typeurl.UnmarshalAny(c.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := v.(*oci.Spec); s.Process.Terminal {
+		t.Error("expected the patch to be applied after the terminal option")
+	}
+}
+
+func TestSpecPatchLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dropins := configPath(dir, "redis") + ".d"
+	if err := os.MkdirAll(dropins, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		configPath(dir, "redis"):                `{"namespace":"services","image":"docker.io/library/redis:4","specPatch":{"hostname":"cache"}}`,
+		filepath.Join(dropins, "10-patch.json"): `{"specPatch":{"process":{"noNewPrivileges":null}}}`,
+	}
+	for p, data := range files {
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, errs, err := checkConfig(dir, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if expected := `{"process":{"noNewPrivileges":null}}`; string(c.SpecPatch) != expected {
+		t.Errorf("expected the drop-in's patch %s to replace the config's but got %s", expected, c.SpecPatch)
+	}
+}
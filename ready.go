@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultStartupWait = 30 * time.Second
+	startupWaitEnv     = "CONTAINERD_PROXY_WAIT"
+	startupMaxDelay    = time.Second
+)
+
+// startupWait is how long commands wait for containerd to be serving, it can
+// be changed with the CONTAINERD_PROXY_WAIT environment variable or the --wait
+// flag and zero disables waiting
+var startupWait = defaultStartupWait
+
+// newClient connects to containerd, waiting for it to be serving
+func newClient() (*containerd.Client, error) {
+	return waitForContainerd(context.Background(), startupWait)
+}
+
+// waitForContainerd polls containerd's socket and version service until it is
+// serving or the timeout is reached
+func waitForContainerd(ctx context.Context, timeout time.Duration) (*containerd.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var (
+		start   = time.Now()
+		delay   = reconnectMinDelay
+		waiting bool
+	)
+	for {
+		client, err := readyClient(ctx)
+		if err == nil {
+			if waiting {
+				fmt.Fprintf(os.Stderr, "containerd at %s ready after %s\n", containerdAddress, time.Since(start).Round(time.Millisecond))
+			}
+			return client, nil
+		}
+		if timeout <= 0 {
+			return nil, err
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "waiting up to %s for containerd at %s: %v\n", timeout, containerdAddress, err)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(err, "containerd at %s not ready after %s", containerdAddress, timeout)
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > startupMaxDelay {
+			delay = startupMaxDelay
+		}
+	}
+}
+
+// readyClient returns a client once containerd answers version requests
+func readyClient(ctx context.Context) (*containerd.Client, error) {
+	if _, err := os.Stat(containerdSocket()); err != nil {
+		return nil, err
+	}
+	client, err := dialContainerd()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.Version(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// containerdSocket returns the path of containerd's unix socket
+func containerdSocket() string {
+	return strings.TrimPrefix(containerdAddress, "unix://")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWaitForContainerd(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	defer func(a string) {
+		containerdAddress = a
+	}(containerdAddress)
+	containerdAddress = f.socket
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		f.start(t)
+	}()
+	client, err := waitForContainerd(context.Background(), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	v, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "v1.1.0" {
+		t.Errorf("expected version v1.1.0 but got %s", v.Version)
+	}
+}
+
+func TestWaitForContainerdTimeout(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	defer func(a string) {
+		containerdAddress = a
+	}(containerdAddress)
+	containerdAddress = f.socket
+
+	// a socket that is listening but not serving grpc is not ready
+	l, err := net.Listen("unix", f.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	start := time.Now()
+	if _, err := waitForContainerd(context.Background(), time.Second); err == nil {
+		t.Fatal("expected wait to fail when containerd is not serving")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected wait to give up after the timeout but took %s", d)
+	}
+	os.Remove(f.socket)
+	if _, err := waitForContainerd(context.Background(), 0); err == nil {
+		t.Fatal("expected a single attempt to fail without containerd")
+	}
+}
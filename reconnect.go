@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultReconnectTimeout  = 5 * time.Minute
+	defaultReconnectMaxDelay = 5 * time.Second
+	reconnectMinDelay        = 100 * time.Millisecond
+)
+
+// Reconnect configures how long the proxy waits for containerd to come back
+// after losing its connection, the task is left running in the meantime
+type Reconnect struct {
+	// Timeout is how long to retry before giving up and killing the task
+	Timeout Duration `json:"timeout"`
+	// MaxDelay caps the backoff between attempts
+	MaxDelay Duration `json:"maxDelay"`
+}
+
+func (r *Reconnect) timeout() time.Duration {
+	if r == nil || r.Timeout.Duration == 0 {
+		return defaultReconnectTimeout
+	}
+	return r.Timeout.Duration
+}
+
+func (r *Reconnect) maxDelay() time.Duration {
+	if r == nil || r.MaxDelay.Duration == 0 {
+		return defaultReconnectMaxDelay
+	}
+	return r.MaxDelay.Duration
+}
+
+func (r *Reconnect) validate(path string) validationErrors {
+	var errs validationErrors
+	if r.Timeout.Duration < 0 {
+		errs.add(path+".timeout", "must not be negative")
+	}
+	if r.MaxDelay.Duration < 0 {
+		errs.add(path+".maxDelay", "must not be negative")
+	}
+	return errs
+}
+
+// reconnection is the result of reconnecting to containerd
+type reconnection struct {
+	client *containerd.Client
+	task   containerd.Task
+	err    error
+}
+
+// reconnect waits for containerd's socket to reappear and attaches to the
+// config's task again, retrying with backoff until the configured timeout
+func reconnect(ctx context.Context, config *Config, opts []cio.Opt) (*containerd.Client, containerd.Task, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Reconnect.timeout())
+	defer cancel()
+	var (
+		delay = reconnectMinDelay
+		max   = config.Reconnect.maxDelay()
+	)
+	for {
+		client, task, err := attach(ctx, config.ID, opts)
+		if err == nil {
+			return client, task, nil
+		}
+		if !isUnavailable(err) {
+			return nil, nil, err
+		}
+		fmt.Fprintf(os.Stderr, "reconnect %s: %v, retrying in %s\n", config.ID, err, delay)
+		select {
+		case <-ctx.Done():
+			return nil, nil, errors.Wrapf(err, "containerd did not return within %s", config.Reconnect.timeout())
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > max {
+			delay = max
+		}
+	}
+}
+
+// attach connects to containerd and loads the task with the provided io
+func attach(ctx context.Context, id string, opts []cio.Opt) (*containerd.Client, containerd.Task, error) {
+	if _, err := os.Stat(containerdSocket()); err != nil {
+		return nil, nil, errdefs.ErrUnavailable
+	}
+	client, err := dialContainerd()
+	if err != nil {
+		return nil, nil, errors.Wrap(errdefs.ErrUnavailable, err.Error())
+	}
+	task, err := getTask(ctx, client, id, opts)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return client, task, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"golang.org/x/sys/unix"
+)
+
+func TestReconnect(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	defer func(a string) {
+		containerdAddress = a
+	}(containerdAddress)
+	containerdAddress = f.socket
+
+	ctx, cancel := context.WithCancel(namespaces.WithNamespace(context.Background(), "test"))
+	defer cancel()
+	config := &Config{
+		ID: "redis",
+		Reconnect: &Reconnect{
+			Timeout:  Duration{10 * time.Second},
+			MaxDelay: Duration{200 * time.Millisecond},
+		},
+	}
+	f.start(t)
+	client, task, err := reconnect(ctx, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait, err := task.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// containerd goes away for longer than a single attempt
+	f.stop()
+	exit := <-wait
+	if err := exit.Error(); err == nil || !isUnavailable(err) {
+		t.Fatalf("expected the wait to fail as unavailable but got %v", err)
+	}
+	if err := trySendSignal(ctx, client, task, unix.SIGCONT); err != nil {
+		t.Fatalf("expected signals to be sent to the pid while containerd is down: %v", err)
+	}
+	client.Close()
+	go func() {
+		time.Sleep(time.Second)
+		f.start(t)
+	}()
+	if client, task, err = reconnect(ctx, config, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if task.Pid() != f.pid {
+		t.Errorf("expected pid %d but got %d", f.pid, task.Pid())
+	}
+
+	// signal delivery and wait are re-established with the new connection
+	if err := trySendSignal(ctx, client, task, unix.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if signals := f.received(); len(signals) != 1 || signals[0] != uint32(unix.SIGTERM) {
+		t.Errorf("expected SIGTERM to be sent through containerd but got %v", signals)
+	}
+	if wait, err = task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	f.exit <- 3
+	select {
+	case exit := <-wait:
+		if exit.Error() != nil || exit.ExitCode() != 3 {
+			t.Errorf("expected exit status 3 but got %d: %v", exit.ExitCode(), exit.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the task exit to be received")
+	}
+}
+
+func TestReconnectTimeout(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	defer func(a string) {
+		containerdAddress = a
+	}(containerdAddress)
+	containerdAddress = f.socket
+
+	config := &Config{
+		ID: "redis",
+		Reconnect: &Reconnect{
+			Timeout: Duration{500 * time.Millisecond},
+		},
+	}
+	start := time.Now()
+	if _, _, err := reconnect(context.Background(), config, nil); err == nil {
+		t.Fatal("expected reconnect to fail without containerd")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected reconnect to give up after the timeout but took %s", d)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// liveSettings are the config fields that are applied to a running proxy on
+// reload. Resources are only set through specPatch and the proxy has no health
+// checks or restart policy of its own, systemd restarts the unit, so every
+// other change is reported as requiring a restart
+var liveSettings = map[string]bool{
+	"logging":      true,
+	"reloadSignal": true,
+	"signals":      true,
+}
+
+// reloadSignal returns the signal that reloads the config, nil when disabled
+func (c *Config) reloadSignal() (os.Signal, error) {
+	if c.ReloadSignal == "" {
+		return nil, nil
+	}
+	s, err := parseSignal(c.ReloadSignal)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reloadConfig re-reads the config and applies the changes that can be made
+// to the running task, changes that need a restart are reported and left out
+// of the returned config
+func reloadConfig(current *Config, logger *taskLogger, filter *signalFilter, keep ...os.Signal) *Config {
+	next, err := loadValidConfigFrom(configDir, current.ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reload %s: %v\n", current.ID, err)
+		return current
+	}
+	var (
+		applied, restart []string
+		result           = *current
+	)
+	for _, name := range configChanges(current, next) {
+		if !liveSettings[name] {
+			fmt.Fprintf(os.Stderr, "reload %s: %s: change requires restart\n", current.ID, name)
+			restart = append(restart, name)
+			continue
+		}
+		if name == "logging" {
+			if err := logger.setDriver(next); err != nil {
+				fmt.Fprintf(os.Stderr, "reload %s: logging: %v\n", current.ID, err)
+				continue
+			}
+		}
+		setConfigField(&result, next, name)
+		applied = append(applied, name)
+	}
+	reload, _ := result.reloadSignal()
+	if err := filter.update(&result, append(keep, reload)...); err != nil {
+		fmt.Fprintf(os.Stderr, "reload %s: signals: %v\n", current.ID, err)
+	}
+	fmt.Fprintf(os.Stderr, "reloaded %s: applied [%s] requires restart [%s]\n",
+		current.ID, strings.Join(applied, " "), strings.Join(restart, " "))
+	return &result
+}
+
+// configChanges returns the json names of the config fields that differ
+func configChanges(a, b *Config) []string {
+	var (
+		changes []string
+		t       = reflect.TypeOf(*a)
+		av, bv  = reflect.ValueOf(*a), reflect.ValueOf(*b)
+	)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			changes = append(changes, name)
+		}
+	}
+	return changes
+}
+
+func setConfigField(dst, src *Config, name string) {
+	fields := jsonFields(reflect.TypeOf(*dst))
+	f := fields[name]
+	reflect.ValueOf(dst).Elem().FieldByIndex(f.Index).Set(reflect.ValueOf(src).Elem().FieldByIndex(f.Index))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) {
+		configDir = d
+	}(configDir)
+	configDir = dir
+
+	current := &Config{
+		ID:           "redis",
+		Namespace:    "services",
+		Image:        "docker.io/library/redis:4",
+		ReloadSignal: "SIGHUP",
+		ExtraArgs:    []string{"--port", "6380"},
+	}
+	logger, err := newTaskLogger(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "redis.log")
+	data := fmt.Sprintf(`{"namespace":"services","image":"docker.io/library/redis:5","reloadSignal":"SIGHUP","logging":{"driver":"file","path":%q}}`, log)
+	if err := ioutil.WriteFile(configPath(dir, "redis"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newSignalFilter(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := reloadConfig(current, logger, filter)
+	if next.Image != current.Image {
+		t.Errorf("expected image change to require a restart but got %s", next.Image)
+	}
+	if next.Logging == nil || next.Logging.Path != log {
+		t.Errorf("expected logging to be applied but got %+v", next.Logging)
+	}
+	if len(next.ExtraArgs) != 2 {
+		t.Errorf("expected extra args to be kept but got %v", next.ExtraArgs)
+	}
+	if _, err := (loggerStream{l: logger}).Write([]byte("reloaded\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "reloaded\n" {
+		t.Errorf("expected output to be written to the new log driver but got %q", out)
+	}
+}
+
+// blockingCloser stands in for a log driver that takes a long time to flush
+type blockingCloser struct {
+	release chan struct{}
+}
+
+func (b blockingCloser) Close() error {
+	<-b.release
+	return nil
+}
+
+func TestReloadDoesNotWaitForClose(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	logger := &taskLogger{
+		stdout: ioutil.Discard,
+		stderr: ioutil.Discard,
+		closer: blockingCloser{release: release},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- logger.setDriver(&Config{ID: "redis"})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("replacing the log driver waited for the previous driver to close")
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a driver set after the logger is closed is closed right away
+	if err := logger.setDriver(&Config{ID: "redis"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := logger.closer.(nopCloser); !ok {
+		t.Errorf("expected the closed logger to keep discarding output but got %T", logger.closer)
+	}
+}
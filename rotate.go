@@ -0,0 +1,187 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const rotateTimestampFormat = "20060102T150405.000000000"
+
+// rotatingFile is a log file that is rotated once it grows past maxSize or
+// becomes older than maxAge, keeping at most maxFiles rotated files
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+	compress bool
+
+	f       *os.File
+	size    int64
+	opened  time.Time
+	pending sync.WaitGroup
+	cleanup sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxFiles int, compress bool) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		maxFiles: maxFiles,
+		compress: compress,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	// the age of the log starts with its first write
+	if r.size == 0 {
+		r.opened = time.Now()
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) shouldRotate(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+n > r.maxSize {
+		return true
+	}
+	return r.maxAge > 0 && time.Since(r.opened) > r.maxAge
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	rotated := r.path + "." + time.Now().UTC().Format(rotateTimestampFormat)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		r.cleanup.Lock()
+		defer r.cleanup.Unlock()
+		if r.compress {
+			// the rotated file is kept uncompressed when compression fails
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "rotate %s: compress: %v\n", rotated, err)
+			}
+		}
+		r.prune()
+	}()
+	return nil
+}
+
+// prune removes the oldest rotated files past maxFiles
+func (r *rotatingFile) prune() {
+	if r.maxFiles <= 0 {
+		return
+	}
+	files := r.rotated()
+	if len(files) <= r.maxFiles {
+		return
+	}
+	for _, f := range files[:len(files)-r.maxFiles] {
+		os.Remove(f)
+	}
+}
+
+// rotated returns the rotated log files ordered from oldest to newest
+func (r *rotatingFile) rotated() []string {
+	files, _ := filepath.Glob(r.path + ".*")
+	var out []string
+	for _, f := range files {
+		// skip compressions that are still in progress
+		if filepath.Ext(f) == ".tmp" {
+			continue
+		}
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending.Wait()
+	return r.f.Close()
+}
+
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(out)
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
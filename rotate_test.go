@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "redis.log")
+	f, err := newRotatingFile(path, 10, 0, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one----\n", "two----\n", "three--\n", "four---\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "four---\n" {
+		t.Errorf("expected current log to contain the last write but got %q", data)
+	}
+	rotated := f.rotated()
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 rotated files but got %d", len(rotated))
+	}
+	data, err = ioutil.ReadFile(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "two----\n" {
+		t.Errorf("expected oldest kept log to be the second write but got %q", data)
+	}
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "redis.log")
+	f, err := newRotatingFile(path, 4, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := f.rotated()
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 rotated file but got %d", len(rotated))
+	}
+	if !strings.HasSuffix(rotated[0], ".gz") {
+		t.Errorf("expected rotated file %s to be compressed", rotated[0])
+	}
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "redis.log")
+	f, err := newRotatingFile(path, 0, 50*time.Millisecond, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an empty log is not rotated however old it is
+	time.Sleep(100 * time.Millisecond)
+	if _, err := f.Write([]byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("two\n")); err != nil {
+		t.Fatal(err)
+	}
+	if rotated := f.rotated(); len(rotated) != 0 {
+		t.Fatalf("expected no rotation before max age but got %v", rotated)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := f.Write([]byte("three\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "three\n" {
+		t.Errorf("expected current log to contain the write after max age but got %q", data)
+	}
+	rotated := f.rotated()
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 rotated file but got %v", rotated)
+	}
+	if data, err = ioutil.ReadFile(rotated[0]); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Errorf("expected the rotated log to contain the writes before max age but got %q", data)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// parseSignal parses a signal by name, with or without the SIG prefix, or number
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if unix.SignalName(syscall.Signal(n)) == "" {
+			return 0, fmt.Errorf("unknown signal %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for i := 1; i < 65; i++ {
+		if unix.SignalName(syscall.Signal(i)) == name {
+			return syscall.Signal(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// defaultIgnoredSignals are not sent to the container unless configured to be
+// forwarded as they are about the proxy's own process or terminal
+var defaultIgnoredSignals = []syscall.Signal{
+	unix.SIGCHLD,
+	unix.SIGCONT,
+	unix.SIGPIPE,
+	unix.SIGURG,
+	unix.SIGWINCH,
+}
+
+// Signals configures how signals received by the proxy are sent to the container
+type Signals struct {
+	// Ignore are never sent to the container
+	Ignore []string `json:"ignore"`
+	// Forward removes signals from the default ignored set
+	Forward []string `json:"forward"`
+	// Translate sends the signal in the value when the key is received
+	Translate map[string]string `json:"translate"`
+}
+
+func (s *Signals) validate(path string) validationErrors {
+	var errs validationErrors
+	for i, name := range s.Ignore {
+		if _, err := parseSignal(name); err != nil {
+			errs.add(fmt.Sprintf("%s.ignore[%d]", path, i), "%v", err)
+		}
+	}
+	for i, name := range s.Forward {
+		if _, err := parseSignal(name); err != nil {
+			errs.add(fmt.Sprintf("%s.forward[%d]", path, i), "%v", err)
+		}
+	}
+	for from, to := range s.Translate {
+		if _, err := parseSignal(from); err != nil {
+			errs.add(path+".translate."+from, "%v", err)
+		}
+		if _, err := parseSignal(to); err != nil {
+			errs.add(path+".translate."+from, "%v", err)
+		}
+	}
+	return errs
+}
+
+// signalFilter drops and translates the signals received by the proxy before
+// they are sent to the container, kept signals are passed through untouched
+// for the proxy to handle itself
+type signalFilter struct {
+	mu        sync.Mutex
+	ignore    map[syscall.Signal]bool
+	translate map[syscall.Signal]syscall.Signal
+	keep      map[syscall.Signal]bool
+}
+
+func newSignalFilter(config *Config, keep ...os.Signal) (*signalFilter, error) {
+	f := &signalFilter{}
+	if err := f.update(config, keep...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// update replaces the filter's rules with the ones in config
+func (f *signalFilter) update(config *Config, keep ...os.Signal) error {
+	var (
+		ignore    = make(map[syscall.Signal]bool)
+		translate = make(map[syscall.Signal]syscall.Signal)
+		kept      = make(map[syscall.Signal]bool)
+		c         = config.Signals
+	)
+	if c == nil {
+		c = &Signals{}
+	}
+	for _, s := range defaultIgnoredSignals {
+		ignore[s] = true
+	}
+	for _, name := range c.Forward {
+		s, err := parseSignal(name)
+		if err != nil {
+			return err
+		}
+		delete(ignore, s)
+	}
+	for _, name := range c.Ignore {
+		s, err := parseSignal(name)
+		if err != nil {
+			return err
+		}
+		ignore[s] = true
+	}
+	for from, to := range c.Translate {
+		fs, err := parseSignal(from)
+		if err != nil {
+			return err
+		}
+		ts, err := parseSignal(to)
+		if err != nil {
+			return err
+		}
+		translate[fs] = ts
+	}
+	for _, s := range keep {
+		if s != nil {
+			kept[s.(syscall.Signal)] = true
+		}
+	}
+	f.mu.Lock()
+	f.ignore, f.translate, f.keep = ignore, translate, kept
+	f.mu.Unlock()
+	return nil
+}
+
+// filter returns the signal to handle for s and false when it is dropped
+func (f *signalFilter) filter(s os.Signal) (os.Signal, bool) {
+	ss, ok := s.(syscall.Signal)
+	if !ok {
+		return s, true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.keep[ss] {
+		return ss, true
+	}
+	if f.ignore[ss] {
+		return nil, false
+	}
+	if t, ok := f.translate[ss]; ok {
+		return t, true
+	}
+	return ss, true
+}
+
+// run filters the signals received on in until ctx is done
+func (f *signalFilter) run(ctx context.Context, in <-chan os.Signal) chan os.Signal {
+	out := make(chan os.Signal, cap(in))
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s := <-in:
+				if s, ok := f.filter(s); ok {
+					select {
+					case out <- s:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var signalFilters = []struct {
+	name     string
+	signals  *Signals
+	keep     []os.Signal
+	received []os.Signal
+	sent     []os.Signal
+}{
+	{
+		name:     "default filter",
+		received: []os.Signal{unix.SIGCHLD, unix.SIGURG, unix.SIGWINCH, unix.SIGCONT, unix.SIGPIPE, unix.SIGTERM},
+		sent:     []os.Signal{unix.SIGTERM},
+	},
+	{
+		name:     "kept by the proxy",
+		keep:     []os.Signal{unix.SIGWINCH, unix.SIGHUP},
+		received: []os.Signal{unix.SIGWINCH, unix.SIGHUP},
+		sent:     []os.Signal{unix.SIGWINCH, unix.SIGHUP},
+	},
+	{
+		name: "translate",
+		signals: &Signals{
+			Translate: map[string]string{"SIGTERM": "SIGQUIT"},
+		},
+		received: []os.Signal{unix.SIGTERM, unix.SIGINT},
+		sent:     []os.Signal{unix.SIGQUIT, unix.SIGINT},
+	},
+	{
+		name: "ignore",
+		signals: &Signals{
+			Ignore: []string{"HUP", "2"},
+		},
+		received: []os.Signal{unix.SIGHUP, unix.SIGINT, unix.SIGUSR1},
+		sent:     []os.Signal{unix.SIGUSR1},
+	},
+	{
+		name: "forward",
+		signals: &Signals{
+			Forward: []string{"SIGWINCH"},
+		},
+		received: []os.Signal{unix.SIGWINCH, unix.SIGCHLD},
+		sent:     []os.Signal{unix.SIGWINCH},
+	},
+	{
+		name: "ignore before translate",
+		signals: &Signals{
+			Ignore:    []string{"SIGTERM"},
+			Translate: map[string]string{"SIGTERM": "SIGQUIT"},
+		},
+		received: []os.Signal{unix.SIGTERM, unix.SIGUSR2},
+		sent:     []os.Signal{unix.SIGUSR2},
+	},
+}
+
+func TestSignalFilter(t *testing.T) {
+	for _, tc := range signalFilters {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := newSignalFilter(&Config{Signals: tc.signals}, tc.keep...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			in := make(chan os.Signal, 64)
+			out := filter.run(ctx, in)
+			for _, s := range tc.received {
+				in <- s
+			}
+			for _, expected := range tc.sent {
+				select {
+				case s := <-out:
+					if s != expected {
+						t.Errorf("expected %v but got %v", expected, s)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("expected %v to be sent", expected)
+				}
+			}
+			select {
+			case s := <-out:
+				t.Errorf("unexpected signal %v", s)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestSignalFilterUpdate(t *testing.T) {
+	filter, err := newSignalFilter(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := filter.filter(unix.SIGTERM); !ok || s != unix.SIGTERM {
+		t.Fatalf("expected SIGTERM to be sent but got %v", s)
+	}
+	if err := filter.update(&Config{Signals: &Signals{Translate: map[string]string{"TERM": "QUIT"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := filter.filter(unix.SIGTERM); !ok || s != unix.SIGQUIT {
+		t.Errorf("expected SIGTERM to be translated to SIGQUIT but got %v", s)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"os"
+	"strings"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/containers"
@@ -12,16 +13,58 @@ import (
 
 func WithCurrentSpec(config *Config) func(ctx context.Context, client *containerd.Client, c *containers.Container) error {
 	return func(ctx context.Context, client *containerd.Client, c *containers.Container) error {
-		s, err := oci.GenerateSpec(ctx, client, c,
+		opts := []oci.SpecOpts{
 			oci.WithProcessArgs(config.GetArgs()...),
-			oci.WithEnv(os.Environ()),
+			oci.WithEnv(containerEnv(os.Environ())),
 			oci.WithParentCgroupDevices,
-		)
+		}
+		if config.Terminal {
+			opts = append(opts, oci.WithTTY)
+		}
+		if config.User != "" || len(config.Groups) > 0 || config.Umask != "" {
+			opts = append(opts, withUser(config))
+		}
+		if config.Userns != nil {
+			uids, gids, err := config.Userns.mappings()
+			if err != nil {
+				return err
+			}
+			opts = append(opts, withUserns(uids, gids))
+		}
+		if config.OCIHooks != nil {
+			opts = append(opts, withOCIHooks(config.OCIHooks))
+		}
+		s, err := oci.GenerateSpec(ctx, client, c, opts...)
+		if err != nil {
+			return err
+		}
+		if len(config.SpecPatch) > 0 {
+			if s, err = patchSpec(s, config.SpecPatch); err != nil {
+				return err
+			}
+		}
 		c.Spec, err = typeurl.MarshalAny(s)
 		return err
 	}
 }
 
+// proxyEnvPrefix is shared by the settings the unit passes to the proxy such
+// as CONTAINERD_PROXY_CONFIG_DIR
+const proxyEnvPrefix = "CONTAINERD_PROXY_"
+
+// containerEnv removes the variables meant for the proxy itself from env so
+// that the container does not talk to systemd or see the proxy's settings
+func containerEnv(env []string) []string {
+	var out []string
+	for _, e := range env {
+		if strings.HasPrefix(e, "NOTIFY_SOCKET=") || strings.HasPrefix(e, proxyEnvPrefix) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
 func WithScope(scope string) func(ctx context.Context, client *containerd.Client, c *containers.Container) error {
 	return func(ctx context.Context, client *containerd.Client, c *containers.Container) error {
 		if c.Labels == nil {
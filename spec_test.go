@@ -0,0 +1,20 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainerEnv(t *testing.T) {
+	env := containerEnv([]string{
+		"PATH=/usr/bin",
+		"NOTIFY_SOCKET=/run/systemd/notify",
+		"CONTAINERD_PROXY_CONFIG_DIR=/etc/proxy",
+		"CONTAINERD_PROXY_WAIT=1m",
+		"REDIS_PORT=6380",
+	})
+	expected := []string{"PATH=/usr/bin", "REDIS_PORT=6380"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %v but got %v", expected, env)
+	}
+}
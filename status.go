@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/typeurl"
+	"github.com/docker/go-units"
+)
+
+// labels recorded on the container by the proxy as its task starts and exits
+const (
+	startsLabel     = "com.crosbymichael/containerd-proxy.starts"
+	exitStatusLabel = "com.crosbymichael/containerd-proxy.exit-status"
+	exitedAtLabel   = "com.crosbymichael/containerd-proxy.exited-at"
+
+	previousRevisionLabel = "boss.io/revision.previous"
+)
+
+type serviceStatus struct {
+	ID        string           `json:"id"`
+	Config    *Config          `json:"config"`
+	Health    string           `json:"health"`
+	Container *containerStatus `json:"container,omitempty"`
+	Task      *taskStatus      `json:"task,omitempty"`
+}
+
+type containerStatus struct {
+	Image            string    `json:"image"`
+	Scope            string    `json:"scope"`
+	SnapshotKey      string    `json:"snapshotKey"`
+	PreviousRevision string    `json:"previousRevision,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	Starts           int       `json:"starts"`
+	Restarts         int       `json:"restarts"`
+	LastExit         *lastExit `json:"lastExit,omitempty"`
+}
+
+type lastExit struct {
+	Status   int       `json:"status"`
+	ExitedAt time.Time `json:"exitedAt"`
+}
+
+type taskStatus struct {
+	Status string         `json:"status"`
+	Pid    uint32         `json:"pid"`
+	Usage  *resourceUsage `json:"usage,omitempty"`
+}
+
+type resourceUsage struct {
+	CPU         uint64 `json:"cpu"`
+	MemoryUsage uint64 `json:"memoryUsage"`
+	MemoryLimit uint64 `json:"memoryLimit"`
+	Pids        uint64 `json:"pids"`
+	PidsLimit   uint64 `json:"pidsLimit"`
+}
+
+// status prints the state of the container and its task
+func status(ctx context.Context, config *Config, args []string) error {
+	var (
+		fs     = flag.NewFlagSet("status", flag.ContinueOnError)
+		asJSON = fs.Bool("json", false, "print the status as json")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	s, err := getStatus(ctx, client, config)
+	if err != nil {
+		return err
+	}
+	s.Health = s.health()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	return s.print()
+}
+
+func getStatus(ctx context.Context, client *containerd.Client, config *Config) (*serviceStatus, error) {
+	s := &serviceStatus{
+		ID:     config.ID,
+		Config: config,
+	}
+	container, err := client.LoadContainer(ctx, config.ID)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		return s, nil
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.Container = &containerStatus{
+		Image:       info.Image,
+		Scope:       info.Labels[ScopeLabel],
+		SnapshotKey: info.SnapshotKey,
+		CreatedAt:   info.CreatedAt,
+		UpdatedAt:   info.UpdatedAt,
+	}
+	if snapshot, err := client.SnapshotService(info.Snapshotter).Stat(ctx, info.SnapshotKey); err == nil {
+		s.Container.PreviousRevision = snapshot.Labels[previousRevisionLabel]
+	}
+	if starts, err := strconv.Atoi(info.Labels[startsLabel]); err == nil {
+		s.Container.Starts = starts
+		if starts > 1 {
+			s.Container.Restarts = starts - 1
+		}
+	}
+	if code, err := strconv.Atoi(info.Labels[exitStatusLabel]); err == nil {
+		exitedAt, _ := time.Parse(time.RFC3339Nano, info.Labels[exitedAtLabel])
+		s.Container.LastExit = &lastExit{
+			Status:   code,
+			ExitedAt: exitedAt,
+		}
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		return s, nil
+	}
+	ts, err := task.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.Task = &taskStatus{
+		Status: string(ts.Status),
+		Pid:    task.Pid(),
+	}
+	if ts.Status == containerd.Running || ts.Status == containerd.Paused {
+		if s.Task.Usage, err = getUsage(ctx, task); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// health summarizes the service without running any checks of its own: a
+// running task is healthy, a failed last exit is unhealthy and a service
+// that is not running otherwise is stopped
+func (s *serviceStatus) health() string {
+	switch {
+	case s.Task != nil && s.Task.Status == string(containerd.Running):
+		return "healthy"
+	case s.Container != nil && s.Container.LastExit != nil && s.Container.LastExit.Status != 0:
+		return "unhealthy"
+	}
+	return "stopped"
+}
+
+func getUsage(ctx context.Context, task containerd.Task) (*resourceUsage, error) {
+	metric, err := task.Metrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := typeurl.UnmarshalAny(metric.Data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(*cgroups.Metrics)
+	if !ok {
+		return nil, fmt.Errorf("unsupported metrics type %s", metric.Data.TypeUrl)
+	}
+	var u resourceUsage
+	if m.CPU != nil && m.CPU.Usage != nil {
+		u.CPU = m.CPU.Usage.Total
+	}
+	if m.Memory != nil && m.Memory.Usage != nil {
+		u.MemoryUsage = m.Memory.Usage.Usage
+		u.MemoryLimit = m.Memory.Usage.Limit
+	}
+	if m.Pids != nil {
+		u.Pids = m.Pids.Current
+		u.PidsLimit = m.Pids.Limit
+	}
+	return &u, nil
+}
+
+func (s *serviceStatus) print() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", s.ID)
+	fmt.Fprintf(w, "Namespace:\t%s\n", s.Config.Namespace)
+	fmt.Fprintf(w, "Configured image:\t%s\n", s.Config.Image)
+	fmt.Fprintf(w, "Configured scope:\t%s\n", s.Config.Scope)
+	fmt.Fprintf(w, "Health:\t%s\n", s.Health)
+	if c := s.Container; c == nil {
+		fmt.Fprintf(w, "Container:\tnot created\n")
+	} else {
+		fmt.Fprintf(w, "Image:\t%s\n", c.Image)
+		fmt.Fprintf(w, "Scope:\t%s\n", c.Scope)
+		fmt.Fprintf(w, "Snapshot:\t%s\n", c.SnapshotKey)
+		if c.PreviousRevision != "" {
+			fmt.Fprintf(w, "Previous revision:\t%s\n", c.PreviousRevision)
+		}
+		fmt.Fprintf(w, "Starts:\t%d\n", c.Starts)
+		fmt.Fprintf(w, "Restarts:\t%d\n", c.Restarts)
+		if c.LastExit != nil {
+			fmt.Fprintf(w, "Last exit:\t%d at %s\n", c.LastExit.Status, c.LastExit.ExitedAt.Format(time.RFC3339))
+		}
+	}
+	if t := s.Task; t == nil {
+		fmt.Fprintf(w, "Task:\tstopped\n")
+	} else {
+		fmt.Fprintf(w, "Task:\t%s\n", t.Status)
+		fmt.Fprintf(w, "Pid:\t%d\n", t.Pid)
+		if u := t.Usage; u != nil {
+			fmt.Fprintf(w, "CPU:\t%s\n", time.Duration(u.CPU))
+			fmt.Fprintf(w, "Memory:\t%s / %s\n", units.BytesSize(float64(u.MemoryUsage)), units.BytesSize(float64(u.MemoryLimit)))
+			fmt.Fprintf(w, "Pids:\t%d\n", u.Pids)
+		}
+	}
+	return w.Flush()
+}
+
+// recordStart increments the number of times the proxy has started the task
+// and removes the previous task's exit so that it is never taken for the exit
+// of this one
+func recordStart(ctx context.Context, container containerd.Container) error {
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return err
+	}
+	starts, _ := strconv.Atoi(labels[startsLabel])
+	_, err = container.SetLabels(ctx, map[string]string{
+		startsLabel:     strconv.Itoa(starts + 1),
+		exitStatusLabel: "",
+		exitedAtLabel:   "",
+	})
+	return err
+}
+
+// recordExit stores the task's exit status on the container
+func recordExit(ctx context.Context, client *containerd.Client, id string, status int, exitedAt time.Time) error {
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = container.SetLabels(ctx, map[string]string{
+		exitStatusLabel: strconv.Itoa(status),
+		exitedAtLabel:   exitedAt.UTC().Format(time.RFC3339Nano),
+	})
+	return err
+}
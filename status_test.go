@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/namespaces"
+)
+
+func TestStatus(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	f.metrics = &cgroups.Metrics{
+		CPU: &cgroups.CPUStat{
+			Usage: &cgroups.CPUUsage{Total: uint64(1500 * time.Millisecond)},
+		},
+		Memory: &cgroups.MemoryStat{
+			Usage: &cgroups.MemoryEntry{Usage: 1 << 20, Limit: 64 << 20},
+		},
+		Pids: &cgroups.PidsStat{Current: 3, Limit: 64},
+	}
+	f.add("services", "redis", "docker.io/library/redis:4", map[string]string{
+		ScopeLabel: "prod",
+	}, true)
+	f.start(t)
+
+	client := f.client(t)
+	defer client.Close()
+	ctx := namespaces.WithNamespace(context.Background(), "services")
+
+	container, err := client.LoadContainer(ctx, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitedAt := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := recordStart(ctx, container); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordExit(ctx, client, "redis", 137, exitedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := getStatus(ctx, client, &Config{ID: "redis", Namespace: "services"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.Container
+	if c == nil {
+		t.Fatal("expected the container's status")
+	}
+	if c.Image != "docker.io/library/redis:4" || c.Scope != "prod" {
+		t.Errorf("unexpected container image %q scope %q", c.Image, c.Scope)
+	}
+	if c.Starts != 2 || c.Restarts != 1 {
+		t.Errorf("expected 2 starts and 1 restart but got %d and %d", c.Starts, c.Restarts)
+	}
+	if c.LastExit == nil || c.LastExit.Status != 137 || !c.LastExit.ExitedAt.Equal(exitedAt) {
+		t.Errorf("expected last exit 137 at %s but got %+v", exitedAt, c.LastExit)
+	}
+	if s.Task == nil || s.Task.Status != "running" || s.Task.Pid != f.pid {
+		t.Fatalf("expected a running task with pid %d but got %+v", f.pid, s.Task)
+	}
+	expected := resourceUsage{
+		CPU:         uint64(1500 * time.Millisecond),
+		MemoryUsage: 1 << 20,
+		MemoryLimit: 64 << 20,
+		Pids:        3,
+		PidsLimit:   64,
+	}
+	if s.Task.Usage == nil || *s.Task.Usage != expected {
+		t.Errorf("expected usage %+v but got %+v", expected, s.Task.Usage)
+	}
+	if h := s.health(); h != "healthy" {
+		t.Errorf("expected a running task to be healthy but got %s", h)
+	}
+
+	s, err = getStatus(ctx, client, &Config{ID: "memcached", Namespace: "services"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Container != nil || s.Task != nil {
+		t.Errorf("expected no container or task for memcached but got %+v", s)
+	}
+}
+
+func TestRecordStartClearsExit(t *testing.T) {
+	f := newFakeContainerd(t)
+	defer f.Close()
+	f.add("services", "redis", "docker.io/library/redis:4", nil, false)
+	f.start(t)
+
+	client := f.client(t)
+	defer client.Close()
+	ctx := namespaces.WithNamespace(context.Background(), "services")
+
+	if err := recordExit(ctx, client, "redis", 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	container, err := client.LoadContainer(ctx, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordStart(ctx, container); err != nil {
+		t.Fatal(err)
+	}
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{exitStatusLabel, exitedAtLabel} {
+		if v, ok := labels[l]; ok {
+			t.Errorf("expected %s to be removed when the task starts but got %q", l, v)
+		}
+	}
+	if labels[startsLabel] != "1" {
+		t.Errorf("expected 1 start but got %q", labels[startsLabel])
+	}
+}
+
+func TestStatusHealth(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		status   serviceStatus
+		expected string
+	}{
+		{
+			name:     "not created",
+			expected: "stopped",
+		},
+		{
+			name: "running",
+			status: serviceStatus{
+				Container: &containerStatus{LastExit: &lastExit{Status: 1}},
+				Task:      &taskStatus{Status: "running"},
+			},
+			expected: "healthy",
+		},
+		{
+			name: "failed",
+			status: serviceStatus{
+				Container: &containerStatus{LastExit: &lastExit{Status: 1}},
+			},
+			expected: "unhealthy",
+		},
+		{
+			name: "exited",
+			status: serviceStatus{
+				Container: &containerStatus{LastExit: &lastExit{Status: 0}},
+				Task:      &taskStatus{Status: "stopped"},
+			},
+			expected: "stopped",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if h := tc.status.health(); h != tc.expected {
+				t.Errorf("expected %s but got %s", tc.expected, h)
+			}
+		})
+	}
+}
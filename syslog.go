@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const (
+	SyslogDriver = "syslog"
+
+	defaultSyslogAddress = "unixgram:///dev/log"
+	defaultSyslogTag     = "{{.ID}}"
+	defaultSyslogBuffer  = 1024
+)
+
+// syslogWriteTimeout bounds each write so a server that stops reading drops
+// messages instead of stalling the queue forever
+var syslogWriteTimeout = 5 * time.Second
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// SyslogTLS configures the client side of a tcp+tls syslog connection
+type SyslogTLS struct {
+	CA                 string `json:"ca"`
+	Cert               string `json:"cert"`
+	Key                string `json:"key"`
+	ServerName         string `json:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+func (t *SyslogTLS) config(host string) (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName: host,
+	}
+	if t == nil {
+		return c, nil
+	}
+	if t.ServerName != "" {
+		c.ServerName = t.ServerName
+	}
+	c.InsecureSkipVerify = t.InsecureSkipVerify
+	if t.CA != "" {
+		data, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CA)
+		}
+		c.RootCAs = pool
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// syslogWriter forwards container output to a syslog server using RFC 5424
+// messages, output is queued so that a slow server never blocks the container
+type syslogWriter struct {
+	network  string
+	address  string
+	tls      *tls.Config
+	facility int
+	tag      string
+	hostname string
+
+	queue   chan []byte
+	done    chan struct{}
+	dropped uint64
+
+	// qmu guards sending on the queue against it being closed while the
+	// task's io is still being copied
+	qmu    sync.Mutex
+	closed bool
+
+	mu      sync.Mutex
+	streams []*lineWriter
+	conn    net.Conn
+	// stopped is set once Close gives up on the queue so that run does not
+	// dial a connection nobody will close
+	stopped bool
+}
+
+func newSyslog(config *Config) (*syslogWriter, error) {
+	l := config.Logging
+	address := l.Address
+	if address == "" {
+		address = defaultSyslogAddress
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	s := &syslogWriter{
+		network: u.Scheme,
+		address: u.Host,
+	}
+	switch u.Scheme {
+	case "unix", "unixgram":
+		s.address = u.Path
+	case "udp", "tcp":
+	case "tcp+tls":
+		s.network = "tcp"
+		host, _, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		if s.tls, err = l.TLS.config(host); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syslog address %q", address)
+	}
+	facility := l.Facility
+	if facility == "" {
+		facility = "daemon"
+	}
+	var ok bool
+	if s.facility, ok = syslogFacilities[facility]; !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	if s.tag, err = syslogTag(l.Tag, config); err != nil {
+		return nil, err
+	}
+	if s.hostname, err = os.Hostname(); err != nil {
+		return nil, err
+	}
+	size := l.BufferSize
+	if size <= 0 {
+		size = defaultSyslogBuffer
+	}
+	s.queue = make(chan []byte, size)
+	s.done = make(chan struct{})
+	go s.run()
+	return s, nil
+}
+
+// syslogTag renders the tag template with the container's details
+func syslogTag(text string, config *Config) (string, error) {
+	if text == "" {
+		text = defaultSyslogTag
+	}
+	t, err := template.New("tag").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, struct {
+		ID        string
+		Namespace string
+		Image     string
+		Scope     string
+	}{
+		ID:        config.ID,
+		Namespace: config.Namespace,
+		Image:     config.Image,
+		Scope:     config.Scope,
+	}); err != nil {
+		return "", err
+	}
+	// APP-NAME is limited to 48 printable characters without spaces
+	tag := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, b.String())
+	if len(tag) > 48 {
+		tag = tag[:48]
+	}
+	if tag == "" {
+		return "-", nil
+	}
+	return tag, nil
+}
+
+// Stream returns a writer that sends each line written as a syslog message
+func (s *syslogWriter) Stream(name string, severity int) io.Writer {
+	w := newLineWriter(func(line []byte) {
+		s.enqueue(s.format(string(line), name, severity))
+	})
+	s.mu.Lock()
+	s.streams = append(s.streams, w)
+	s.mu.Unlock()
+	return w
+}
+
+func (s *syslogWriter) format(message, stream string, severity int) []byte {
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		s.facility*8+severity,
+		time.Now().Format(time.RFC3339Nano),
+		s.hostname,
+		s.tag,
+		os.Getpid(),
+		stream,
+		message,
+	))
+}
+
+// enqueue drops the message when the queue is full or closed rather than
+// blocking
+func (s *syslogWriter) enqueue(m []byte) {
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	select {
+	case s.queue <- m:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *syslogWriter) run() {
+	defer close(s.done)
+	for m := range s.queue {
+		if err := s.send(m); err != nil {
+			s.mu.Lock()
+			if s.conn != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+			s.mu.Unlock()
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (s *syslogWriter) send(m []byte) error {
+	s.mu.Lock()
+	conn, stopped := s.conn, s.stopped
+	s.mu.Unlock()
+	if stopped {
+		return errors.New("syslog writer closed")
+	}
+	if conn == nil {
+		var err error
+		if conn, err = s.dial(); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if s.stopped {
+			s.mu.Unlock()
+			conn.Close()
+			return errors.New("syslog writer closed")
+		}
+		s.conn = conn
+		s.mu.Unlock()
+	}
+	// the count is only reset once the notice is written so that it is
+	// reported on the next connection when the write fails
+	if n := atomic.LoadUint64(&s.dropped); n > 0 {
+		notice := s.format(fmt.Sprintf("containerd-proxy dropped %d messages", n), "proxy", 4)
+		if err := s.write(conn, notice); err != nil {
+			return err
+		}
+		atomic.AddUint64(&s.dropped, ^(n - 1))
+	}
+	return s.write(conn, m)
+}
+
+func (s *syslogWriter) write(conn net.Conn, m []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write(s.frame(m))
+	return err
+}
+
+func (s *syslogWriter) dial() (net.Conn, error) {
+	d := &net.Dialer{
+		Timeout: 5 * time.Second,
+	}
+	if s.tls != nil {
+		return tls.DialWithDialer(d, s.network, s.address, s.tls)
+	}
+	return d.Dial(s.network, s.address)
+}
+
+// frame uses octet counting on stream transports and one message per
+// datagram otherwise
+func (s *syslogWriter) frame(m []byte) []byte {
+	switch s.network {
+	case "tcp", "unix":
+		return append([]byte(fmt.Sprintf("%d ", len(m))), m...)
+	}
+	return m
+}
+
+// Close flushes buffered output and waits a short time for the queue to drain
+func (s *syslogWriter) Close() error {
+	s.mu.Lock()
+	for _, w := range s.streams {
+		w.Close()
+	}
+	s.mu.Unlock()
+	s.qmu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.qmu.Unlock()
+	var err error
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		err = errors.New("timeout flushing syslog messages")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
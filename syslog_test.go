@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyslogUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	s, err := newSyslog(&Config{
+		ID: "redis",
+		Logging: &Logging{
+			Driver:   SyslogDriver,
+			Address:  "udp://" + server.LocalAddr().String(),
+			Facility: "local0",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stream("stderr", stderrPriority).Write([]byte("oops\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(string(buf[:n]), " ", 8)
+	if len(parts) != 8 {
+		t.Fatalf("invalid message %q", buf[:n])
+	}
+	// local0 (16) * 8 + err (3)
+	if parts[0] != "<131>1" {
+		t.Errorf("expected priority and version <131>1 but got %s", parts[0])
+	}
+	if parts[3] != "redis" {
+		t.Errorf("expected app name redis but got %s", parts[3])
+	}
+	if parts[5] != "stderr" {
+		t.Errorf("expected msgid stderr but got %s", parts[5])
+	}
+	if parts[7] != "oops" {
+		t.Errorf("expected message oops but got %q", parts[7])
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s, err := newSyslog(&Config{
+		ID: "redis",
+		Logging: &Logging{
+			Driver:  SyslogDriver,
+			Address: "tcp://" + l.Addr().String(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stream("stdout", stdoutPriority).Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, expected := range []string{"one", "two"} {
+		size, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make([]byte, n)
+		if _, err := r.Read(m); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(m), " "+expected) {
+			t.Errorf("expected message %q to end with %s", m, expected)
+		}
+	}
+	s.Close()
+}
+
+func TestSyslogDoesNotBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "log")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// accept the connection but never read from it until released
+	release := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			<-release
+			conn.Close()
+		}
+	}()
+
+	s, err := newSyslog(&Config{
+		ID: "redis",
+		Logging: &Logging{
+			Driver:     SyslogDriver,
+			Address:    "unix://" + socket,
+			BufferSize: 8,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		w     = s.Stream("stdout", stdoutPriority)
+		line  = []byte(strings.Repeat("x", 4096) + "\n")
+		start = time.Now()
+	)
+	for i := 0; i < 2048; i++ {
+		if _, err := w.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("writes blocked for %s on a stalled syslog server", d)
+	}
+	close(release)
+	s.Close()
+}
+
+func TestSyslogWriteDeadline(t *testing.T) {
+	timeout := syslogWriteTimeout
+	syslogWriteTimeout = 50 * time.Millisecond
+	defer func() { syslogWriteTimeout = timeout }()
+
+	dir, err := ioutil.TempDir("", "containerd-proxy-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "log")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// accept connections but never read from them so every write eventually
+	// blocks
+	var accepted uint64
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+			atomic.AddUint64(&accepted, 1)
+		}
+	}()
+
+	s, err := newSyslog(&Config{
+		ID: "redis",
+		Logging: &Logging{
+			Driver:     SyslogDriver,
+			Address:    "unix://" + socket,
+			BufferSize: 256,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		w    = s.Stream("stdout", stdoutPriority)
+		line = []byte(strings.Repeat("x", 4096) + "\n")
+	)
+	for i := 0; i < 2048; i++ {
+		if _, err := w.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("close blocked for %s on a stalled syslog server", d)
+	}
+	if n := atomic.LoadUint64(&accepted); n < 2 {
+		t.Errorf("expected a timed out write to reconnect, got %d connections", n)
+	}
+}
+
+func TestSyslogWriteAfterClose(t *testing.T) {
+	s, err := newSyslog(&Config{
+		ID: "redis",
+		Logging: &Logging{
+			Driver:  SyslogDriver,
+			Address: "udp://127.0.0.1:9",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := s.Stream("stdout", stdoutPriority)
+	s.Close()
+	if _, err := w.Write([]byte("late\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyslogTag(t *testing.T) {
+	tag, err := syslogTag("{{.ID}}/{{.Scope}} {{.Image}}", &Config{
+		ID:    "redis",
+		Scope: "ce",
+		Image: "redis:latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "redis/ce_redis:latest" {
+		t.Errorf("unexpected tag %q", tag)
+	}
+}
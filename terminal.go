@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/containerd/containerd"
+	"golang.org/x/sys/unix"
+)
+
+// hostTerminal is the proxy's controlling terminal placed into raw mode
+// while a container with a terminal is running
+type hostTerminal struct {
+	fd    uintptr
+	state unix.Termios
+}
+
+// newHostTerminal puts f in raw mode and returns nil if f is not a terminal
+func newHostTerminal(f *os.File) (*hostTerminal, error) {
+	fd := f.Fd()
+	state, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		// not a tty, the container still gets its own pty
+		return nil, nil
+	}
+	raw := *state
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := setTermios(fd, &raw); err != nil {
+		return nil, err
+	}
+	return &hostTerminal{
+		fd:    fd,
+		state: *state,
+	}, nil
+}
+
+// Restore returns the terminal to the state it had before raw mode
+func (t *hostTerminal) Restore() error {
+	return setTermios(t.fd, &t.state)
+}
+
+// Resize propagates the host terminal's window size to the process
+func (t *hostTerminal) Resize(ctx context.Context, p containerd.Process) error {
+	ws, err := unix.IoctlGetWinsize(int(t.fd), unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	return p.Resize(ctx, uint32(ws.Col), uint32(ws.Row))
+}
+
+// resize propagates the window size when the proxy has a terminal, failures
+// are logged as the process keeps running at its previous size
+func resize(ctx context.Context, con *hostTerminal, p containerd.Process, id string) {
+	if con == nil {
+		return
+	}
+	if err := con.Resize(ctx, p); err != nil {
+		fmt.Fprintf(os.Stderr, "resize %s: %v\n", id, err)
+	}
+}
+
+func setTermios(fd uintptr, t *unix.Termios) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, unix.TCSETS, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/containerd/containerd"
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the master and slave of a new pseudo terminal
+func openPty(t *testing.T) (*os.File, *os.File) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), unix.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	return master, slave
+}
+
+func ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+type resizeProcess struct {
+	containerd.Process
+	width, height uint32
+	err           error
+}
+
+func (p *resizeProcess) Resize(ctx context.Context, w, h uint32) error {
+	p.width, p.height = w, h
+	return p.err
+}
+
+func TestHostTerminal(t *testing.T) {
+	master, slave := openPty(t)
+	defer master.Close()
+	defer slave.Close()
+
+	if err := ioctl(slave.Fd(), unix.TIOCSWINSZ, unsafe.Pointer(&unix.Winsize{Col: 132, Row: 43})); err != nil {
+		t.Fatal(err)
+	}
+	before, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	con, err := newHostTerminal(slave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if con == nil {
+		t.Fatal("expected a terminal for the pty")
+	}
+	raw, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Lflag&(unix.ECHO|unix.ICANON) != 0 {
+		t.Error("expected the terminal to be in raw mode")
+	}
+	p := &resizeProcess{}
+	resize(context.Background(), con, p, "redis")
+	if p.width != 132 || p.height != 43 {
+		t.Errorf("expected the process to be resized to 132x43 but got %dx%d", p.width, p.height)
+	}
+	if err := con.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Lflag != before.Lflag {
+		t.Errorf("expected the terminal's flags to be restored to %x but got %x", before.Lflag, restored.Lflag)
+	}
+}
+
+func TestHostTerminalNotATTY(t *testing.T) {
+	f, err := ioutil.TempFile("", "containerd-proxy-terminal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	con, err := newHostTerminal(f)
+	if err != nil || con != nil {
+		t.Fatalf("expected no terminal for a file but got %v, %v", con, err)
+	}
+	// resizing without a terminal does nothing
+	p := &resizeProcess{}
+	resize(context.Background(), con, p, "redis")
+	if p.width != 0 || p.height != 0 {
+		t.Errorf("expected no resize without a terminal but got %dx%d", p.width, p.height)
+	}
+}
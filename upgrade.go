@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/crosbymichael/boss/flux"
+	"github.com/pkg/errors"
+)
+
+// upgrade applies the configured image to the stopped container
+func upgrade(ctx context.Context, config *Config) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	container, err := client.LoadContainer(ctx, config.ID)
+	if err != nil {
+		return err
+	}
+	if err := checkRunning(ctx, container); err != nil {
+		return err
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if !config.ShouldUpgrade(info.Image, info.Labels[ScopeLabel]) {
+		if err := unpinImages(ctx, client, info.Image, normalizeImage(config.Image)); err != nil {
+			return err
+		}
+		fmt.Printf("%s is up to date with %s\n", config.ID, info.Image)
+		return nil
+	}
+	image, err := config.GetImage(ctx, client)
+	if err != nil {
+		return err
+	}
+	if err := upgradeContainer(ctx, client, config, container, info, image); err != nil {
+		return err
+	}
+	fmt.Printf("upgraded %s from %s to %s\n", config.ID, info.Image, image.Name())
+	return nil
+}
+
+// upgradeContainer moves the container to image, running the upgrade hooks
+// around the update, and unpins the images once the new snapshot is taken
+func upgradeContainer(ctx context.Context, client *containerd.Client, config *Config, container containerd.Container, info containers.Container, image containerd.Image) error {
+	vars := map[string]string{
+		"OLD_IMAGE": info.Image,
+		"NEW_IMAGE": image.Name(),
+		"REVISION":  info.SnapshotKey,
+	}
+	if err := runHooks(ctx, config, PreUpgradeHook, vars); err != nil {
+		return err
+	}
+	if err := container.Update(ctx, withUpgrade(config, image), WithScope(config.Scope)); err != nil {
+		return err
+	}
+	if err := unpinImages(ctx, client, info.Image, image.Name()); err != nil {
+		return err
+	}
+	updated, err := container.Info(ctx)
+	if err != nil {
+		return err
+	}
+	vars["PREVIOUS_REVISION"] = info.SnapshotKey
+	vars["REVISION"] = updated.SnapshotKey
+	return runHooks(ctx, config, PostUpgradeHook, vars)
+}
+
+// rollback moves the stopped container back to its previous snapshot revision
+func rollback(ctx context.Context, config *Config) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	container, err := client.LoadContainer(ctx, config.ID)
+	if err != nil {
+		return err
+	}
+	if err := checkRunning(ctx, container); err != nil {
+		return err
+	}
+	if err := container.Update(ctx, flux.WithRollback); err != nil {
+		if err == flux.ErrNoPreviousRevision {
+			return errors.Errorf("container %s has no previous revision", config.ID)
+		}
+		return err
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rolled back %s to %s\n", config.ID, info.Image)
+	if config.ShouldUpgrade(info.Image, info.Labels[ScopeLabel]) {
+		fmt.Printf("the configured image %s will be applied on the next run\n", config.Image)
+	}
+	return nil
+}
+
+// plan prints the changes that run would make to the container
+func plan(ctx context.Context, config *Config) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	source, err := imageSource(ctx, client, config)
+	if err != nil {
+		return err
+	}
+	container, err := client.LoadContainer(ctx, config.ID)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return err
+		}
+		fmt.Printf("create %s from %s (%s)\n", config.ID, config.Image, source)
+		return nil
+	}
+	if err := checkRunning(ctx, container); err != nil {
+		fmt.Println(err)
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if !config.ShouldUpgrade(info.Image, info.Labels[ScopeLabel]) {
+		fmt.Printf("%s is up to date with %s\n", config.ID, info.Image)
+		return nil
+	}
+	fmt.Printf("upgrade %s from %s to %s (%s)\n", config.ID, info.Image, config.Image, source)
+	return nil
+}
+
+// imageSource describes where GetImage will get the configured image from
+func imageSource(ctx context.Context, client *containerd.Client, config *Config) (string, error) {
+	if _, err := client.GetImage(ctx, normalizeImage(config.Image)); err != nil {
+		if !errdefs.IsNotFound(err) {
+			return "", err
+		}
+		if config.ImagePath != "" {
+			return "import " + config.ImagePath, nil
+		}
+		return "pull", nil
+	}
+	return "local", nil
+}
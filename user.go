@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/continuity/fs"
+	"github.com/opencontainers/image-spec/identity"
+	"github.com/opencontainers/runc/libcontainer/user"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// withUser resolves the config's user and groups against the container's
+// rootfs and sets them along with the umask on the process
+func withUser(config *Config) oci.SpecOpts {
+	return func(ctx context.Context, client oci.Client, c *containers.Container, s *oci.Spec) error {
+		if s.Process == nil {
+			s.Process = &specs.Process{}
+		}
+		if config.Umask != "" {
+			umask, err := parseUmask(config.Umask)
+			if err != nil {
+				return err
+			}
+			s.Process.User.Umask = umask
+		}
+		if config.User == "" && len(config.Groups) == 0 {
+			return nil
+		}
+		return withRootfs(ctx, client, c, func(root string) error {
+			u, err := resolveUser(root, config.User, config.Groups)
+			if err != nil {
+				return err
+			}
+			s.Process.User.UID = u.UID
+			s.Process.User.GID = u.GID
+			s.Process.User.AdditionalGids = u.AdditionalGids
+			return nil
+		})
+	}
+}
+
+// resolveUser looks up the user, in the form name or uid with an optional
+// :group or :gid, and the supplementary groups in the rootfs's /etc/passwd
+// and /etc/group. The user's groups in /etc/group are included
+func resolveUser(root, name string, groups []string) (specs.User, error) {
+	passwdPath, err := fs.RootPath(root, "/etc/passwd")
+	if err != nil {
+		return specs.User{}, err
+	}
+	groupPath, err := fs.RootPath(root, "/etc/group")
+	if err != nil {
+		return specs.User{}, err
+	}
+	u, err := user.GetExecUserPath(name, nil, passwdPath, groupPath)
+	if err != nil {
+		return specs.User{}, errors.Wrapf(err, "user %q", name)
+	}
+	gids := u.Sgids
+	if len(groups) > 0 {
+		additional, err := user.GetAdditionalGroupsPath(groups, groupPath)
+		if err != nil {
+			return specs.User{}, errors.Wrapf(err, "groups %v", groups)
+		}
+		gids = append(gids, additional...)
+	}
+	var (
+		seen   = make(map[int]bool)
+		result = specs.User{
+			UID: uint32(u.Uid),
+			GID: uint32(u.Gid),
+		}
+	)
+	sort.Ints(gids)
+	for _, g := range gids {
+		if g == u.Gid || seen[g] {
+			continue
+		}
+		seen[g] = true
+		result.AdditionalGids = append(result.AdditionalGids, uint32(g))
+	}
+	return result, nil
+}
+
+// viewImage points c at a read-only view of the configured image's rootfs so
+// that the user can be resolved before the container is created. The returned
+// func removes the view
+func viewImage(ctx context.Context, client *containerd.Client, config *Config, c *containers.Container) (func(), error) {
+	image, err := client.GetImage(ctx, normalizeImage(config.Image))
+	if err != nil {
+		return nil, err
+	}
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		sn  = client.SnapshotService(containerd.DefaultSnapshotter)
+		now = time.Now()
+		key = fmt.Sprintf("%s-view-%d", c.ID, now.UnixNano())
+	)
+	// the view is a gc root as nothing else references it while it is used
+	if _, err := sn.View(ctx, key, identity.ChainID(diffIDs).String(), snapshots.WithLabels(map[string]string{
+		gcRootLabel: now.UTC().Format(time.RFC3339),
+	})); err != nil {
+		return nil, err
+	}
+	c.Snapshotter, c.SnapshotKey = containerd.DefaultSnapshotter, key
+	return func() {
+		sn.Remove(ctx, key)
+	}, nil
+}
+
+// withRootfs calls fn with the container's rootfs snapshot mounted read-only
+func withRootfs(ctx context.Context, client oci.Client, c *containers.Container, fn func(root string) error) error {
+	if c.Snapshotter == "" || c.SnapshotKey == "" {
+		return errors.Errorf("rootfs snapshot not created for container %s", c.ID)
+	}
+	mounts, err := client.SnapshotService(c.Snapshotter).Mounts(ctx, c.SnapshotKey)
+	if err != nil {
+		return err
+	}
+	return mount.WithTempMount(ctx, readonlyMounts(mounts), fn)
+}
+
+// readonlyMounts returns a copy of mounts that are mounted read-only
+func readonlyMounts(mounts []mount.Mount) []mount.Mount {
+	var ro []mount.Mount
+	for _, m := range mounts {
+		options := []string{"ro"}
+		for _, o := range m.Options {
+			if o != "rw" && o != "ro" {
+				options = append(options, o)
+			}
+		}
+		m.Options = options
+		ro = append(ro, m)
+	}
+	return ro
+}
+
+// parseUmask parses an octal umask such as 0027, a umask of zero cannot be
+// represented in the spec and leaves the runtime's default
+func parseUmask(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return 0, fmt.Errorf("invalid umask %q, expected an octal mode such as 0027", s)
+	}
+	return uint32(v), nil
+}
+
+func validateUser(c *Config) validationErrors {
+	var errs validationErrors
+	if c.User != "" {
+		parts := strings.Split(c.User, ":")
+		if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+			errs.add("$.user", "%q must be a name or uid with an optional :group or :gid", c.User)
+		}
+	}
+	for i, g := range c.Groups {
+		if g == "" || strings.Contains(g, ":") {
+			errs.add(fmt.Sprintf("$.groups[%d]", i), "%q is not a group name or gid", g)
+		}
+	}
+	if c.Umask != "" {
+		// the spec omits a zero umask so the runtime's default would be used
+		if v, err := parseUmask(c.Umask); err != nil {
+			errs.add("$.umask", "%v", err)
+		} else if v == 0 {
+			errs.add("$.umask", "a umask of 0 cannot be set, the runtime's default of 0022 would be used")
+		}
+	}
+	return errs
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	testPasswd = `root:x:0:0:root:/root:/bin/sh
+redis:x:999:1000:redis:/data:/bin/false
+`
+	testGroup = `root:x:0:
+redis:x:1000:
+adm:x:4:redis
+audio:x:29:
+`
+)
+
+var users = []struct {
+	user     string
+	groups   []string
+	expected specs.User
+	err      bool
+}{
+	{user: "redis", expected: specs.User{UID: 999, GID: 1000, AdditionalGids: []uint32{4}}},
+	{user: "999", expected: specs.User{UID: 999, GID: 1000, AdditionalGids: []uint32{4}}},
+	{user: "redis:audio", expected: specs.User{UID: 999, GID: 29}},
+	{user: "1500:1500", expected: specs.User{UID: 1500, GID: 1500}},
+	{user: "redis", groups: []string{"audio", "4", "2000"}, expected: specs.User{UID: 999, GID: 1000, AdditionalGids: []uint32{4, 29, 2000}}},
+	{user: "", groups: []string{"redis"}, expected: specs.User{UID: 0, GID: 0, AdditionalGids: []uint32{1000}}},
+	{user: "nobody", err: true},
+	{user: "redis", groups: []string{"video"}, err: true},
+}
+
+func TestResolveUser(t *testing.T) {
+	root, err := ioutil.TempDir("", "containerd-proxy-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(testPasswd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc", "group"), []byte(testGroup), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range users {
+		u, err := resolveUser(root, tc.user, tc.groups)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s %v: expected an error", tc.user, tc.groups)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %v: %v", tc.user, tc.groups, err)
+			continue
+		}
+		if !reflect.DeepEqual(u, tc.expected) {
+			t.Errorf("%s %v: expected %+v but got %+v", tc.user, tc.groups, tc.expected, u)
+		}
+	}
+}
+
+func TestWithUser(t *testing.T) {
+	s := &oci.Spec{}
+	c := &containers.Container{ID: "redis"}
+	if err := withUser(&Config{Umask: "0027"})(context.Background(), nil, c, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Process.User.Umask != 027 {
+		t.Errorf("expected umask 027 but got %o", s.Process.User.Umask)
+	}
+	if err := withUser(&Config{User: "redis"})(context.Background(), nil, c, s); err == nil {
+		t.Error("expected an error resolving a user without a rootfs snapshot")
+	}
+}
+
+func TestReadonlyMounts(t *testing.T) {
+	mounts := []mount.Mount{
+		{Type: "overlay", Source: "overlay", Options: []string{"workdir=/w", "upperdir=/u", "lowerdir=/l"}},
+		{Type: "bind", Source: "/snapshots/1", Options: []string{"rbind", "rw"}},
+	}
+	expected := []mount.Mount{
+		{Type: "overlay", Source: "overlay", Options: []string{"ro", "workdir=/w", "upperdir=/u", "lowerdir=/l"}},
+		{Type: "bind", Source: "/snapshots/1", Options: []string{"ro", "rbind"}},
+	}
+	if ro := readonlyMounts(mounts); !reflect.DeepEqual(ro, expected) {
+		t.Errorf("expected %+v but got %+v", expected, ro)
+	}
+	if mounts[1].Options[1] != "rw" {
+		t.Error("expected the snapshot's mounts not to be changed")
+	}
+}
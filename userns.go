@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/diff/apply"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/rootfs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/crosbymichael/boss/flux"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	"github.com/opencontainers/runc/libcontainer/user"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// usernsLabel records the mappings a snapshot's ownership was remapped with
+	usernsLabel = "com.crosbymichael/containerd-proxy.userns"
+	// remapOwnerLabel is the pid of the proxy remapping an active snapshot
+	remapOwnerLabel = "com.crosbymichael/containerd-proxy.remap-owner"
+)
+
+// remapPollInterval is how often a proxy checks on a remap done by another
+var remapPollInterval = 100 * time.Millisecond
+
+var (
+	subuidPath = "/etc/subuid"
+	subgidPath = "/etc/subgid"
+)
+
+// Userns runs the container in a user namespace, the mappings are taken from
+// /etc/subuid and /etc/subgid for User or are set explicitly
+type Userns struct {
+	User        string      `json:"user"`
+	UIDMappings []IDMapping `json:"uidMappings"`
+	GIDMappings []IDMapping `json:"gidMappings"`
+}
+
+// IDMapping maps Size ids starting at ContainerID to ids starting at HostID
+type IDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// mappings returns the uid and gid mappings for the user namespace
+func (u *Userns) mappings() (uids, gids []specs.LinuxIDMapping, err error) {
+	if u.User == "" {
+		return specMappings(u.UIDMappings), specMappings(u.GIDMappings), nil
+	}
+	if uids, err = subIDMappings(subuidPath, u.User); err != nil {
+		return nil, nil, err
+	}
+	if gids, err = subIDMappings(subgidPath, u.User); err != nil {
+		return nil, nil, err
+	}
+	return uids, gids, nil
+}
+
+func specMappings(m []IDMapping) []specs.LinuxIDMapping {
+	var out []specs.LinuxIDMapping
+	for _, e := range m {
+		out = append(out, specs.LinuxIDMapping{
+			ContainerID: e.ContainerID,
+			HostID:      e.HostID,
+			Size:        e.Size,
+		})
+	}
+	return out
+}
+
+// subIDMappings maps the user's subordinate id ranges in path to consecutive
+// container ids starting at 0
+func subIDMappings(path, name string) ([]specs.LinuxIDMapping, error) {
+	ids, err := user.ParseSubIDFileFilter(path, func(s user.SubID) bool {
+		return s.Name == name
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, errors.Errorf("no subordinate ids for %s in %s", name, path)
+	}
+	var (
+		out       []specs.LinuxIDMapping
+		container uint32
+	)
+	for _, id := range ids {
+		out = append(out, specs.LinuxIDMapping{
+			ContainerID: container,
+			HostID:      uint32(id.SubID),
+			Size:        uint32(id.Count),
+		})
+		container += uint32(id.Count)
+	}
+	return out, nil
+}
+
+func (u *Userns) validate(path string) validationErrors {
+	var errs validationErrors
+	explicit := len(u.UIDMappings) > 0 || len(u.GIDMappings) > 0
+	switch {
+	case u.User != "" && explicit:
+		errs.add(path, "set either user or explicit mappings")
+		return errs
+	case u.User == "" && (len(u.UIDMappings) == 0 || len(u.GIDMappings) == 0):
+		errs.add(path, "a user or both uidMappings and gidMappings are required")
+		return errs
+	}
+	uids, gids, err := u.mappings()
+	if err != nil {
+		errs.add(path+".user", "%v", err)
+		return errs
+	}
+	for name, m := range map[string][]specs.LinuxIDMapping{
+		"uidMappings": uids,
+		"gidMappings": gids,
+	} {
+		if err := checkMappings(m); err != nil {
+			errs.add(path+"."+name, "%v", err)
+		}
+	}
+	return errs
+}
+
+// checkMappings ensures that no ranges are empty and that neither the
+// container nor the host ranges overlap
+func checkMappings(m []specs.LinuxIDMapping) error {
+	overlaps := func(a, asize, b, bsize uint32) bool {
+		return uint64(a) < uint64(b)+uint64(bsize) && uint64(b) < uint64(a)+uint64(asize)
+	}
+	for i, e := range m {
+		if e.Size == 0 {
+			return errors.Errorf("mapping %d has no size", i)
+		}
+		for j, o := range m[:i] {
+			if overlaps(e.ContainerID, e.Size, o.ContainerID, o.Size) {
+				return errors.Errorf("container range of mapping %d overlaps mapping %d", i, j)
+			}
+			if overlaps(e.HostID, e.Size, o.HostID, o.Size) {
+				return errors.Errorf("host range of mapping %d overlaps mapping %d", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+func formatMappings(uids, gids []specs.LinuxIDMapping) string {
+	var parts []string
+	for _, m := range [][]specs.LinuxIDMapping{uids, gids} {
+		var ranges []string
+		for _, e := range m {
+			ranges = append(ranges, fmt.Sprintf("%d:%d:%d", e.ContainerID, e.HostID, e.Size))
+		}
+		parts = append(parts, strings.Join(ranges, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// withUserns adds a user namespace with the mappings to the spec
+func withUserns(uids, gids []specs.LinuxIDMapping) oci.SpecOpts {
+	return func(ctx context.Context, client oci.Client, c *containers.Container, s *oci.Spec) error {
+		if s.Linux == nil {
+			s.Linux = &specs.Linux{}
+		}
+		s.Linux.UIDMappings = uids
+		s.Linux.GIDMappings = gids
+		for _, ns := range s.Linux.Namespaces {
+			if ns.Type == specs.UserNamespace {
+				return nil
+			}
+		}
+		s.Linux.Namespaces = append(s.Linux.Namespaces, specs.LinuxNamespace{
+			Type: specs.UserNamespace,
+		})
+		return nil
+	}
+}
+
+// revisionTimestampFormat is flux's format for revision keys, revisions on a
+// remapped parent are created the same way so that flux can roll back to them
+const revisionTimestampFormat = "01-02-2006-15:04:05"
+
+// withNewSnapshot creates the container's first revision, in a user
+// namespace it is created on the image's remapped rootfs
+func withNewSnapshot(config *Config, image containerd.Image) containerd.NewContainerOpts {
+	if config.Userns == nil {
+		return flux.WithNewSnapshot(image)
+	}
+	return func(ctx context.Context, client *containerd.Client, c *containers.Container) error {
+		if c.Snapshotter == "" {
+			c.Snapshotter = containerd.DefaultSnapshotter
+		}
+		key, _, err := newRevision(ctx, client, config, c, image, "")
+		if err != nil {
+			return err
+		}
+		c.SnapshotKey = key
+		c.Image = image.Name()
+		return nil
+	}
+}
+
+// withUpgrade moves the container to a new revision for image carrying over
+// the changes made in the current one, like flux.WithUpgrade
+func withUpgrade(config *Config, image containerd.Image) containerd.UpdateContainerOpts {
+	if config.Userns == nil {
+		return flux.WithUpgrade(image)
+	}
+	return func(ctx context.Context, client *containerd.Client, c *containers.Container) error {
+		key, mounts, err := newRevision(ctx, client, config, c, image, c.SnapshotKey)
+		if err != nil {
+			return err
+		}
+		diff, err := rootfs.CreateDiff(ctx, c.SnapshotKey, client.SnapshotService(c.Snapshotter), client.DiffService())
+		if err != nil {
+			return err
+		}
+		if _, err := apply.NewFileSystemApplier(client.ContentStore()).Apply(ctx, diff, mounts); err != nil {
+			return err
+		}
+		c.SnapshotKey = key
+		c.Image = image.Name()
+		return nil
+	}
+}
+
+// newRevision prepares a flux revision for the container on the remapped
+// rootfs of image
+func newRevision(ctx context.Context, client *containerd.Client, config *Config, c *containers.Container, image containerd.Image, previous string) (string, []mount.Mount, error) {
+	uids, gids, err := config.Userns.mappings()
+	if err != nil {
+		return "", nil, err
+	}
+	parent, err := remappedParent(ctx, client.SnapshotService(c.Snapshotter), image, uids, gids)
+	if err != nil {
+		return "", nil, err
+	}
+	var (
+		now    = time.Now()
+		key    = fmt.Sprintf("boss.io.%s.%s", c.ID, now.Format(revisionTimestampFormat))
+		labels = map[string]string{
+			gcRootLabel:     now.Format(time.RFC3339),
+			flux.ImageLabel: image.Name(),
+			usernsLabel:     formatMappings(uids, gids),
+		}
+	)
+	if previous != "" {
+		labels[previousRevisionLabel] = previous
+	}
+	sn := client.SnapshotService(c.Snapshotter)
+	mounts, err := sn.Prepare(ctx, key, parent, snapshots.WithLabels(labels))
+	if err != nil {
+		return "", nil, err
+	}
+	// the revision now keeps the shared parent from being collected
+	if _, err := sn.Update(ctx, snapshots.Info{Name: parent}, "labels."+gcRootLabel); err != nil {
+		sn.Remove(ctx, key)
+		return "", nil, err
+	}
+	return key, mounts, nil
+}
+
+// remappedParent returns a committed snapshot of image's rootfs with its
+// ownership changed to the host ids of the mappings. It is shared by every
+// container running image with the same mappings so the image is only copied
+// and remapped once, a proxy starting while another remaps the image waits
+// for it to finish
+func remappedParent(ctx context.Context, sn snapshots.Snapshotter, image containerd.Image, uids, gids []specs.LinuxIDMapping) (string, error) {
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return "", err
+	}
+	var (
+		mappings = formatMappings(uids, gids)
+		parent   = identity.ChainID(diffIDs).String()
+		key      = fmt.Sprintf("%s-userns-%s", parent, digest.FromString(mappings).Hex()[:12])
+		remap    = key + "-remap"
+	)
+	for {
+		if _, err := sn.Stat(ctx, key); err == nil {
+			return key, nil
+		} else if !errdefs.IsNotFound(err) {
+			return "", err
+		}
+		// both snapshots are gc roots until a container's snapshot is taken
+		// from the committed parent
+		now := time.Now().UTC().Format(time.RFC3339)
+		mounts, err := sn.Prepare(ctx, remap, parent, snapshots.WithLabels(map[string]string{
+			gcRootLabel:     now,
+			remapOwnerLabel: strconv.Itoa(os.Getpid()),
+		}))
+		if err == nil {
+			if err := mount.WithTempMount(ctx, mounts, func(root string) error {
+				return filepath.Walk(root, remapFS(uids, gids))
+			}); err != nil {
+				sn.Remove(ctx, remap)
+				return "", err
+			}
+			if err := sn.Commit(ctx, key, remap, snapshots.WithLabels(map[string]string{
+				gcRootLabel: now,
+				usernsLabel: mappings,
+			})); err != nil {
+				if !errdefs.IsAlreadyExists(err) {
+					return "", err
+				}
+				sn.Remove(ctx, remap)
+			}
+			return key, nil
+		}
+		if !errdefs.IsAlreadyExists(err) {
+			return "", err
+		}
+		info, err := sn.Stat(ctx, remap)
+		switch {
+		case errdefs.IsNotFound(err):
+			// committed or removed since, check again
+			continue
+		case err != nil:
+			return "", err
+		}
+		// only what is left of an interrupted remap is removed
+		if !remapOwnerRunning(info.Labels[remapOwnerLabel]) {
+			if err := sn.Remove(ctx, remap); err != nil && !errdefs.IsNotFound(err) {
+				return "", err
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(remapPollInterval):
+		}
+	}
+}
+
+// remapOwnerRunning returns true when the proxy with the pid is still running
+func remapOwnerRunning(pid string) bool {
+	p, err := strconv.Atoi(pid)
+	if err != nil || p <= 0 {
+		return false
+	}
+	err = unix.Kill(p, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// checkUserns ensures the container's rootfs was remapped for the configured
+// mappings, changing them requires the container to be recreated
+func checkUserns(config *Config) containerd.UpdateContainerOpts {
+	return func(ctx context.Context, client *containerd.Client, c *containers.Container) error {
+		var current string
+		if config.Userns != nil {
+			uids, gids, err := config.Userns.mappings()
+			if err != nil {
+				return err
+			}
+			current = formatMappings(uids, gids)
+		}
+		info, err := client.SnapshotService(c.Snapshotter).Stat(ctx, c.SnapshotKey)
+		if err != nil {
+			return err
+		}
+		switch previous := info.Labels[usernsLabel]; {
+		case previous == current:
+			return nil
+		case previous == "":
+			return errors.Errorf("rootfs of %s is not remapped, the container must be recreated for a user namespace", c.ID)
+		case current == "":
+			return errors.Errorf("rootfs of %s is remapped for %s, the container must be recreated without a user namespace", c.ID, previous)
+		default:
+			return errors.Errorf("rootfs of %s is remapped for %s, the container must be recreated for %s", c.ID, previous, current)
+		}
+	}
+}
+
+// fileCapability is the xattr holding a file's capabilities, it is removed by
+// the kernel when the file's owner changes
+const fileCapability = "security.capability"
+
+// inode identifies a file across its hard links
+type inode struct {
+	dev, ino uint64
+}
+
+func remapFS(uids, gids []specs.LinuxIDMapping) filepath.WalkFunc {
+	// hard links are given the ids of the first link remapped rather than
+	// being remapped again, a link broken by a copy up still gets them
+	linked := make(map[inode][2]uint32)
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		var (
+			stat     = info.Sys().(*syscall.Stat_t)
+			uid, gid uint32
+		)
+		id := inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+		if ids, ok := linked[id]; ok {
+			uid, gid = ids[0], ids[1]
+		} else {
+			uid, gid = remapID(stat.Uid, uids), remapID(stat.Gid, gids)
+			if !info.IsDir() && stat.Nlink > 1 {
+				linked[id] = [2]uint32{uid, gid}
+			}
+		}
+		if uid == stat.Uid && gid == stat.Gid {
+			return nil
+		}
+		var caps []byte
+		if info.Mode().IsRegular() {
+			if caps, err = getxattr(path, fileCapability); err != nil {
+				return err
+			}
+		}
+		// lchown so that symlinks to host files are not followed
+		if err := os.Lchown(path, int(uid), int(gid)); err != nil {
+			return err
+		}
+		// chown clears the setuid and setgid bits
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			if err := os.Chmod(path, info.Mode()); err != nil {
+				return err
+			}
+		}
+		if caps != nil {
+			return unix.Lsetxattr(path, fileCapability, caps, 0)
+		}
+		return nil
+	}
+}
+
+// getxattr returns the value of the xattr or nil when it is not set
+func getxattr(path, attr string) ([]byte, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Lgetxattr(path, attr, buf)
+		switch err {
+		case nil:
+			return buf[:n], nil
+		case unix.ENODATA, unix.ENOTSUP:
+			return nil, nil
+		case unix.ERANGE:
+			buf = make([]byte, len(buf)*2)
+		default:
+			return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+		}
+	}
+}
+
+// remapID returns the host id for a container id, unmapped ids are returned
+// as is
+func remapID(id uint32, m []specs.LinuxIDMapping) uint32 {
+	for _, e := range m {
+		if id >= e.ContainerID && id-e.ContainerID < e.Size {
+			return e.HostID + id - e.ContainerID
+		}
+	}
+	return id
+}
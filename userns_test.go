@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+var testMappings = []specs.LinuxIDMapping{
+	{ContainerID: 0, HostID: 100000, Size: 65536},
+}
+
+func TestSubIDMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-subid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(u, g string) {
+		subuidPath, subgidPath = u, g
+	}(subuidPath, subgidPath)
+	subuidPath = filepath.Join(dir, "subuid")
+	subgidPath = filepath.Join(dir, "subgid")
+	if err := ioutil.WriteFile(subuidPath, []byte("other:200000:65536\nredis:100000:1000\nredis:300000:64536\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(subgidPath, []byte("redis:100000:65536\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uids, gids, err := (&Userns{User: "redis"}).mappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 100000, Size: 1000},
+		{ContainerID: 1000, HostID: 300000, Size: 64536},
+	}
+	if !reflect.DeepEqual(uids, expected) {
+		t.Errorf("expected uid mappings %+v but got %+v", expected, uids)
+	}
+	if !reflect.DeepEqual(gids, testMappings) {
+		t.Errorf("expected gid mappings %+v but got %+v", testMappings, gids)
+	}
+	if errs := (&Userns{User: "nobody"}).validate("$.userns"); len(errs) != 1 || errs[0].Path != "$.userns.user" {
+		t.Errorf("expected an error for a user without subordinate ids but got %v", errs)
+	}
+}
+
+var usernsValidations = []struct {
+	userns Userns
+	paths  []string
+}{
+	{
+		userns: Userns{
+			UIDMappings: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			GIDMappings: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+	},
+	{
+		userns: Userns{
+			UIDMappings: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+		paths: []string{"$.userns"},
+	},
+	{
+		userns: Userns{
+			User:        "redis",
+			UIDMappings: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+		paths: []string{"$.userns"},
+	},
+	{
+		userns: Userns{
+			UIDMappings: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 1000}, {ContainerID: 1000, HostID: 100500, Size: 1000}},
+			GIDMappings: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 0}},
+		},
+		paths: []string{"$.userns.gidMappings", "$.userns.uidMappings"},
+	},
+	{
+		// root keeps the host's root while every other id is remapped
+		userns: Userns{
+			UIDMappings: []IDMapping{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65535}},
+			GIDMappings: []IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+	},
+}
+
+func TestUsernsValidation(t *testing.T) {
+	for i, tc := range usernsValidations {
+		var paths []string
+		for _, e := range tc.userns.validate("$.userns") {
+			paths = append(paths, e.Path)
+		}
+		if len(paths) > 1 && paths[0] > paths[1] {
+			paths[0], paths[1] = paths[1], paths[0]
+		}
+		if !reflect.DeepEqual(paths, tc.paths) {
+			t.Errorf("%d: expected errors for %v but got %v", i, tc.paths, paths)
+		}
+	}
+}
+
+func TestWithUserns(t *testing.T) {
+	s := &oci.Spec{
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}},
+		},
+	}
+	if err := withUserns(testMappings, testMappings)(context.Background(), nil, &containers.Container{}, s); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Linux.UIDMappings, testMappings) || !reflect.DeepEqual(s.Linux.GIDMappings, testMappings) {
+		t.Errorf("unexpected mappings %+v %+v", s.Linux.UIDMappings, s.Linux.GIDMappings)
+	}
+	expected := []specs.LinuxNamespace{{Type: specs.PIDNamespace}, {Type: specs.UserNamespace}}
+	if !reflect.DeepEqual(s.Linux.Namespaces, expected) {
+		t.Errorf("expected namespaces %+v but got %+v", expected, s.Linux.Namespaces)
+	}
+}
+
+func TestRemapFS(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("remapping ownership requires root")
+	}
+	root, err := ioutil.TempDir("", "containerd-proxy-remap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string][2]int{
+		"root":     {0, 0},
+		"redis":    {999, 1000},
+		"unmapped": {70000, 70000},
+	}
+	for name, ids := range files {
+		p := filepath.Join(root, name)
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Lchown(p, ids[0], ids[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setuid := filepath.Join(root, "setuid")
+	if err := ioutil.WriteFile(setuid, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(setuid, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// a version 2 file capability granting CAP_NET_RAW
+	ping, caps := filepath.Join(root, "ping"), []byte{1, 0, 0, 2, 0, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if err := ioutil.WriteFile(ping, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Lsetxattr(ping, fileCapability, caps, 0); err != nil {
+		if err != unix.ENOTSUP {
+			t.Fatal(err)
+		}
+		caps = nil
+	}
+	if err := filepath.Walk(root, remapFS(testMappings, testMappings)); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][2]uint32{
+		"root":     {100000, 100000},
+		"redis":    {100999, 101000},
+		"unmapped": {70000, 70000},
+		"setuid":   {100000, 100000},
+		"ping":     {100000, 100000},
+		"link":     {100000, 100000},
+	}
+	for name, ids := range expected {
+		fi, err := os.Lstat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := fi.Sys().(*syscall.Stat_t)
+		if stat.Uid != ids[0] || stat.Gid != ids[1] {
+			t.Errorf("%s: expected %d:%d but got %d:%d", name, ids[0], ids[1], stat.Uid, stat.Gid)
+		}
+	}
+	fi, err := os.Stat(setuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSetuid == 0 {
+		t.Error("expected the setuid bit to be kept")
+	}
+	if caps != nil {
+		if v, err := getxattr(ping, fileCapability); err != nil || !bytes.Equal(v, caps) {
+			t.Errorf("expected file capabilities %v to be kept but got %v, %v", caps, v, err)
+		}
+	}
+	fi, err = os.Stat("/etc/passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Sys().(*syscall.Stat_t).Uid != 0 {
+		t.Error("expected the symlink's target not to be changed")
+	}
+}
+
+func TestRemapFSHardLinks(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("remapping ownership requires root")
+	}
+	root, err := ioutil.TempDir("", "containerd-proxy-remap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := filepath.Join(root, "data")
+	if err := ioutil.WriteFile(data, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(data, 5, 5); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"link1", "link2"} {
+		if err := os.Link(data, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// remapped ids are still inside the mapping so a link remapped again
+	// would move on to 2005
+	overlapping := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1000, Size: 65536},
+	}
+	if err := filepath.Walk(root, remapFS(overlapping, overlapping)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"data", "link1", "link2"} {
+		fi, err := os.Lstat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := fi.Sys().(*syscall.Stat_t)
+		if stat.Uid != 1005 || stat.Gid != 1005 {
+			t.Errorf("%s: expected 1005:1005 but got %d:%d", name, stat.Uid, stat.Gid)
+		}
+	}
+}
+
+// dirSnapshotter keeps each snapshot as a copy of its parent's directory
+type dirSnapshotter struct {
+	snapshots.Snapshotter
+	root string
+
+	mu       sync.Mutex
+	infos    map[string]snapshots.Info
+	prepared int
+}
+
+func (d *dirSnapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info, ok := d.infos[key]
+	if !ok {
+		return snapshots.Info{}, errdefs.ErrNotFound
+	}
+	return info, nil
+}
+
+func (d *dirSnapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.infos[key]; ok {
+		return nil, errdefs.ErrAlreadyExists
+	}
+	info := snapshots.Info{Name: key, Parent: parent, Kind: snapshots.KindActive}
+	for _, o := range opts {
+		if err := o(&info); err != nil {
+			return nil, err
+		}
+	}
+	dir := filepath.Join(d.root, key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := exec.Command("cp", "-a", filepath.Join(d.root, parent)+"/.", dir).Run(); err != nil {
+		return nil, err
+	}
+	d.prepared++
+	d.infos[key] = info
+	return []mount.Mount{{Type: "bind", Source: dir, Options: []string{"rbind", "rw"}}}, nil
+}
+
+func (d *dirSnapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.infos[name]; ok {
+		return errdefs.ErrAlreadyExists
+	}
+	info := snapshots.Info{Name: name, Parent: d.infos[key].Parent, Kind: snapshots.KindCommitted}
+	for _, o := range opts {
+		if err := o(&info); err != nil {
+			return err
+		}
+	}
+	delete(d.infos, key)
+	d.infos[name] = info
+	return os.Rename(filepath.Join(d.root, key), filepath.Join(d.root, name))
+}
+
+func (d *dirSnapshotter) Remove(ctx context.Context, key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.infos[key]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(d.infos, key)
+	return os.RemoveAll(filepath.Join(d.root, key))
+}
+
+type rootfsImage struct {
+	containerd.Image
+	diffIDs []digest.Digest
+}
+
+func (i rootfsImage) RootFS(ctx context.Context) ([]digest.Digest, error) {
+	return i.diffIDs, nil
+}
+
+func TestRemappedParent(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("remapping ownership requires root")
+	}
+	root, err := ioutil.TempDir("", "containerd-proxy-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	var (
+		ctx   = context.Background()
+		image = rootfsImage{diffIDs: []digest.Digest{digest.FromString("layer")}}
+		chain = identity.ChainID(image.diffIDs).String()
+		sn    = &dirSnapshotter{root: root, infos: map[string]snapshots.Info{}}
+	)
+	if err := os.MkdirAll(filepath.Join(root, chain, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sn.infos[chain] = snapshots.Info{Name: chain, Kind: snapshots.KindCommitted}
+	key, err := remappedParent(ctx, sn, image, testMappings, testMappings)
+	if err != nil {
+		if strings.Contains(err.Error(), "operation not permitted") {
+			t.Skip("mounting is not permitted")
+		}
+		t.Fatal(err)
+	}
+	info := sn.infos[key]
+	if info.Kind != snapshots.KindCommitted || info.Parent != chain || info.Labels[usernsLabel] != formatMappings(testMappings, testMappings) {
+		t.Errorf("unexpected remapped snapshot %+v", info)
+	}
+	if info.Labels[gcRootLabel] == "" {
+		t.Error("expected the remapped snapshot to be a gc root until it has a child")
+	}
+	fi, err := os.Lstat(filepath.Join(root, key, "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := fi.Sys().(*syscall.Stat_t).Uid; uid != 100000 {
+		t.Errorf("expected the rootfs to be owned by 100000 but got %d", uid)
+	}
+	fi, err = os.Lstat(filepath.Join(root, chain, "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := fi.Sys().(*syscall.Stat_t).Uid; uid != 0 {
+		t.Errorf("expected the image's rootfs not to be changed but it is owned by %d", uid)
+	}
+	again, err := remappedParent(ctx, sn, image, testMappings, testMappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != key || sn.prepared != 1 {
+		t.Errorf("expected %s to be reused but got %s after %d prepares", key, again, sn.prepared)
+	}
+}
+
+func TestRemappedParentInProgress(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("remapping ownership requires root")
+	}
+	defer func(d time.Duration) {
+		remapPollInterval = d
+	}(remapPollInterval)
+	remapPollInterval = 10 * time.Millisecond
+
+	// a pid that is no longer running
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		owner    string
+		prepared int
+	}{
+		{
+			// another proxy is remapping and commits the snapshot
+			name:  "running",
+			owner: strconv.Itoa(os.Getpid()),
+		},
+		{
+			// the proxy remapping was interrupted so the remap is done again
+			name:     "interrupted",
+			owner:    strconv.Itoa(exited.Process.Pid),
+			prepared: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "containerd-proxy-snapshots")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			var (
+				ctx   = context.Background()
+				image = rootfsImage{diffIDs: []digest.Digest{digest.FromString("layer")}}
+				chain = identity.ChainID(image.diffIDs).String()
+				sn    = &dirSnapshotter{root: root, infos: map[string]snapshots.Info{}}
+				key   = chain + "-userns-" + digest.FromString(formatMappings(testMappings, testMappings)).Hex()[:12]
+				remap = key + "-remap"
+			)
+			for _, dir := range []string{chain, remap} {
+				if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sn.infos[chain] = snapshots.Info{Name: chain, Kind: snapshots.KindCommitted}
+			sn.infos[remap] = snapshots.Info{
+				Name:   remap,
+				Parent: chain,
+				Kind:   snapshots.KindActive,
+				Labels: map[string]string{remapOwnerLabel: tc.owner},
+			}
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			if tc.prepared == 0 {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					sn.Commit(ctx, key, remap)
+				}()
+			}
+			parent, err := remappedParent(ctx, sn, image, testMappings, testMappings)
+			if err != nil {
+				if strings.Contains(err.Error(), "operation not permitted") {
+					t.Skip("mounting is not permitted")
+				}
+				t.Fatal(err)
+			}
+			if parent != key {
+				t.Errorf("expected %s but got %s", key, parent)
+			}
+			if sn.prepared != tc.prepared {
+				t.Errorf("expected %d prepares but got %d", tc.prepared, sn.prepared)
+			}
+			if _, err := sn.Stat(ctx, remap); !errdefs.IsNotFound(err) {
+				t.Errorf("expected %s to be gone but got %v", remap, err)
+			}
+		})
+	}
+}
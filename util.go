@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"syscall"
 	"time"
 
@@ -15,12 +14,12 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func getTask(ctx context.Context, client *containerd.Client, id string) (containerd.Task, error) {
+func getTask(ctx context.Context, client *containerd.Client, id string, opts []cio.Opt) (containerd.Task, error) {
 	container, err := client.LoadContainer(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	return container.Task(ctx, cio.NewAttach(cio.WithStdio))
+	return container.Task(ctx, cio.NewAttach(opts...))
 }
 
 func trySendSignal(ctx context.Context, client *containerd.Client, task containerd.Task, s os.Signal) error {
@@ -34,24 +33,22 @@ func trySendSignal(ctx context.Context, client *containerd.Client, task containe
 	return unix.Kill(int(task.Pid()), s.(syscall.Signal))
 }
 
-func reconnect(ctx context.Context, id string) (*containerd.Client, containerd.Task, error) {
-	client, err := containerd.New(
-		defaults.DefaultAddress,
+// containerdAddress is the socket the proxy connects to containerd on
+var containerdAddress = defaults.DefaultAddress
+
+// dialContainerd makes a single attempt to connect to containerd
+func dialContainerd() (*containerd.Client, error) {
+	return containerd.New(
+		containerdAddress,
 		containerd.WithDefaultRuntime("io.containerd.process.v1"),
 		containerd.WithTimeout(1*time.Second),
 	)
-	if err != nil {
-		return nil, nil, err
-	}
-	t, err := getTask(ctx, client, id)
-	if err != nil {
-		return nil, nil, err
-	}
-	return client, t, nil
 }
 
+// isUnavailable returns true for unavailable errors from grpc calls and
+// errors already converted by the containerd client
 func isUnavailable(err error) bool {
-	return errdefs.IsUnavailable(errdefs.FromGRPC(err))
+	return errdefs.IsUnavailable(err) || errdefs.IsUnavailable(errdefs.FromGRPC(err))
 }
 
 type exitError struct {
@@ -63,10 +60,9 @@ func (e *exitError) Error() string {
 }
 
 func exit(err error) {
+	if eerr, ok := err.(*exitError); ok {
+		os.Exit(eerr.Status)
+	}
 	fmt.Fprintln(os.Stderr, err)
 	os.Exit(1)
 }
-
-func getID() string {
-	return filepath.Base(os.Args[0])
-}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/identifiers"
+	"github.com/containerd/containerd/reference"
+)
+
+var scopeRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// fieldError is a problem with the value at a JSON path in a config, File
+// is set when the problem is in a specific config layer
+type fieldError struct {
+	File string
+	Path string
+	Err  error
+}
+
+func (e *fieldError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s (%s): %v", e.Path, e.File, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+type validationErrors []*fieldError
+
+func (e validationErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *validationErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, &fieldError{
+		Path: path,
+		Err:  fmt.Errorf(format, args...),
+	})
+}
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// unknownFields walks the decoded value against the type it is decoded into
+// and removes the fields that are unknown, field names are matched exactly
+// rather than case insensitively and list fields may be suffixed with + to
+// append to a lower config layer
+func unknownFields(v interface{}, t reflect.Type, path string) validationErrors {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshaler) {
+		return nil
+	}
+	var errs validationErrors
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := jsonFields(t)
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, ok := fields[k]
+			if !ok && strings.HasSuffix(k, "+") {
+				f, ok = fields[strings.TrimSuffix(k, "+")]
+				ok = ok && f.Type.Kind() == reflect.Slice
+			}
+			if !ok {
+				errs = append(errs, unknownField(path+"."+k, k, fields))
+				delete(m, k)
+				continue
+			}
+			errs = append(errs, unknownFields(m[k], f.Type, path+"."+k)...)
+		}
+	case reflect.Slice:
+		s, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, e := range s {
+			errs = append(errs, unknownFields(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			errs = append(errs, unknownFields(m[k], t.Elem(), path+"."+k)...)
+		}
+	}
+	return errs
+}
+
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func unknownField(path, name string, fields map[string]reflect.StructField) *fieldError {
+	for f := range fields {
+		if strings.EqualFold(f, name) {
+			return &fieldError{
+				Path: path,
+				Err:  fmt.Errorf("unknown field, did you mean %q", f),
+			}
+		}
+	}
+	return &fieldError{
+		Path: path,
+		Err:  fmt.Errorf("unknown field"),
+	}
+}
+
+// normalizeImage adds the docker.io registry and library repository to short
+// image names the way docker does, containerd requires the registry to be part
+// of the reference. The configured name is kept as written and only normalized
+// when it is resolved or compared
+func normalizeImage(name string) string {
+	i := strings.IndexRune(name, '/')
+	if i < 0 {
+		return "docker.io/library/" + name
+	}
+	if host := name[:i]; !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "docker.io/" + name
+	}
+	return name
+}
+
+// Validate checks the config's values without contacting containerd
+func (c *Config) Validate() validationErrors {
+	var errs validationErrors
+	if err := identifiers.Validate(c.ID); err != nil {
+		errs.add("$", "invalid id: %v", err)
+	}
+	if c.Namespace == "" {
+		errs.add("$.namespace", "namespace is required")
+	} else if err := identifiers.Validate(c.Namespace); err != nil {
+		errs.add("$.namespace", "%v", err)
+	}
+	if c.Image == "" {
+		errs.add("$.image", "image is required")
+	} else if _, err := reference.Parse(normalizeImage(c.Image)); err != nil {
+		errs.add("$.image", "invalid image reference %q: %v", c.Image, err)
+	}
+	if c.ImagePath != "" {
+		info, err := os.Stat(c.ImagePath)
+		switch {
+		case err != nil:
+			errs.add("$.imagePath", "%v", err)
+		case !info.Mode().IsRegular():
+			errs.add("$.imagePath", "%s is not a file", c.ImagePath)
+		}
+	}
+	if c.Scope != "" && c.Scope != AnyScope && !scopeRe.MatchString(c.Scope) {
+		errs.add("$.scope", "scope %q must be %q or match %v", c.Scope, AnyScope, scopeRe)
+	}
+	if c.Logging != nil {
+		errs = append(errs, c.Logging.validate("$.logging")...)
+	}
+	if _, err := c.reloadSignal(); err != nil {
+		errs.add("$.reloadSignal", "%v", err)
+	}
+	if c.Signals != nil {
+		errs = append(errs, c.Signals.validate("$.signals")...)
+	}
+	if c.Reconnect != nil {
+		errs = append(errs, c.Reconnect.validate("$.reconnect")...)
+	}
+	if c.OOMExitCode < 0 || c.OOMExitCode > 255 {
+		errs.add("$.oomExitCode", "must be between 0 and 255, 0 uses the default of %d", defaultOOMExitCode)
+	}
+	if c.Hooks != nil {
+		errs = append(errs, c.Hooks.validate("$.hooks")...)
+	}
+	if c.OCIHooks != nil {
+		errs = append(errs, c.OCIHooks.validate("$.ociHooks")...)
+	}
+	errs = append(errs, validateUser(c)...)
+	if c.Userns != nil {
+		errs = append(errs, c.Userns.validate("$.userns")...)
+	}
+	if len(c.SpecPatch) > 0 {
+		errs = append(errs, validateSpecPatch(c.SpecPatch, "$.specPatch")...)
+	}
+	return errs
+}
+
+// decodeConfig reads the merged config for id with strict decoding, the
+// values themselves are not checked. The returned error is set when the config
+// cannot be read at all
+func decodeConfig(dir, id string) (*Config, validationErrors, error) {
+	merged, _, errs, err := readLayers(dir, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &Config{
+		ID: id,
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, nil, err
+	}
+	return c, append(errs, c.interpolate(os.LookupEnv)...), nil
+}
+
+// checkConfig reads the merged config for id and returns every problem found
+// in it, the returned error is set when the config cannot be read at all
+func checkConfig(dir, id string) (*Config, validationErrors, error) {
+	c, errs, err := decodeConfig(dir, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, append(errs, c.Validate()...), nil
+}
+
+// validate checks one or, without an id, every config and reports all errors
+func validate(ctx context.Context, config *Config, args []string, signals chan os.Signal) error {
+	ids := []string{config.ID}
+	if config.ID == "" {
+		var err error
+		if ids, err = configIDs(configDir); err != nil {
+			return err
+		}
+	}
+	invalid := 0
+	for _, id := range ids {
+		name := filepath.Base(configPath(configDir, id))
+		_, errs, err := checkConfig(configDir, id)
+		if err != nil {
+			errs = validationErrors{{Path: "$", Err: err}}
+		}
+		if len(errs) == 0 {
+			fmt.Printf("%s: ok\n", name)
+			continue
+		}
+		invalid++
+		for _, e := range errs {
+			fmt.Printf("%s: %v\n", name, e)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d configs are invalid", invalid, len(ids))
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var validations = []struct {
+	Config string
+	Paths  []string
+}{
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","scope":"ce"}`,
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","imagepath":"/tmp/redis.tar"}`,
+		Paths:  []string{"$.imagepath"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","logging":{"driver":"file","maxsize":10,"maxAge":"1h"}}`,
+		Paths:  []string{"$.logging.maxsize"},
+	},
+	{
+		Config: `{"namespace":"services"}`,
+		Paths:  []string{"$.image"},
+	},
+	{
+		Config: `{"namespace":"services","image":"redis:latest"}`,
+	},
+	{
+		Config: `{"namespace":"services","image":"localhost:5000/redis:latest"}`,
+	},
+	{
+		Config: `{"namespace":"bad namespace","image":"docker.io/library/redis:latest","scope":"-x"}`,
+		Paths:  []string{"$.namespace", "$.scope"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","imagePath":"/does/not/exist"}`,
+		Paths:  []string{"$.imagePath"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","logging":{"driver":"syslog","facility":"nope"}}`,
+		Paths:  []string{"$.logging.facility"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","logging":{"driver":"fluentd"}}`,
+		Paths:  []string{"$.logging.driver"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","signals":{"ignore":["SIGNOPE"],"translate":{"SIGTERM":"SIGQUIT"}}}`,
+		Paths:  []string{"$.signals.ignore[0]"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","reconnect":{"timeout":"-1m","maxDelay":"5s"}}`,
+		Paths:  []string{"$.reconnect.timeout"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","oomExitCode":300}`,
+		Paths:  []string{"$.oomExitCode"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","hooks":{"preStart":[{"args":[]}],"postStop":[{"args":["/bin/true"],"onFailure":"retry"}]}}`,
+		Paths:  []string{"$.hooks.postStop[0].onFailure", "$.hooks.preStart[0].args"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","ociHooks":{"prestart":[{"path":"/bin/true"},{"path":"bin/true"},{"path":"/etc/passwd"}],"createContainer":[{"path":"/not/on/the/host"}],"startContainer":[{"path":"/only/in/the/container"}]}}`,
+		Paths:  []string{"$.ociHooks.prestart[1].path", "$.ociHooks.prestart[2].path", "$.ociHooks.createContainer[0].path"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","specPatch":"hostname"}`,
+		Paths:  []string{"$.specPatch"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","user":"redis:","groups":["adm",""],"umask":"0999"}`,
+		Paths:  []string{"$.user", "$.groups[1]", "$.umask"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","umask":"0000"}`,
+		Paths:  []string{"$.umask"},
+	},
+	{
+		Config: `{"namespace":"services","image":"docker.io/library/redis:latest","userns":{"uidMappings":[{"containerID":0,"hostID":100000,"size":65536}]}}`,
+		Paths:  []string{"$.userns"},
+	},
+}
+
+func TestNormalizeImage(t *testing.T) {
+	for name, expected := range map[string]string{
+		"redis":                        "docker.io/library/redis",
+		"redis:latest":                 "docker.io/library/redis:latest",
+		"crosbymichael/redis:4":        "docker.io/crosbymichael/redis:4",
+		"docker.io/library/redis:4":    "docker.io/library/redis:4",
+		"localhost/redis":              "localhost/redis",
+		"localhost:5000/redis:latest":  "localhost:5000/redis:latest",
+		"registry.example.com/redis:4": "registry.example.com/redis:4",
+	} {
+		if n := normalizeImage(name); n != expected {
+			t.Errorf("expected %s to be normalized to %s but got %s", name, expected, n)
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, v := range validations {
+		if err := ioutil.WriteFile(configPath(dir, "redis"), []byte(v.Config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, errs, err := checkConfig(dir, "redis")
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		var paths []string
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		if !reflect.DeepEqual(paths, v.Paths) {
+			t.Errorf("%d: expected errors for %v but got %v", i, v.Paths, errs)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerd-proxy-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		config  string
+		decodes bool
+		valid   bool
+	}{
+		{
+			config:  `{"namespace":"services","image":"redis:4"}`,
+			decodes: true,
+			valid:   true,
+		},
+		{
+			// stop and post-stop still work with a config that is no longer valid
+			config:  `{"namespace":"services"}`,
+			decodes: true,
+		},
+		{
+			config: `{"namespace":"services","image":"redis:4","imageName":"redis"}`,
+		},
+	} {
+		if err := ioutil.WriteFile(configPath(dir, "redis"), []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfigFrom(dir, "redis"); (err == nil) != tc.decodes {
+			t.Errorf("%s: expected decoding to succeed %v but got %v", tc.config, tc.decodes, err)
+		}
+		if _, err := loadValidConfigFrom(dir, "redis"); (err == nil) != tc.valid {
+			t.Errorf("%s: expected validation to succeed %v but got %v", tc.config, tc.valid, err)
+		}
+	}
+}